@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// ffmpeg's native AAC encoder takes -q:a from 0.1 (smallest) to 2 (best).
+const (
+	minAACQuality = 0.1
+	maxAACQuality = 2.0
+)
+
+func isValidAACQuality(quality float64) bool {
+	return quality == 0 || (quality >= minAACQuality && quality <= maxAACQuality)
+}
+
+// aacFor reports whether downloads in chatID are converted to m4a, and the
+// VBR quality to use; zero quality means the fixed bitrate is kept.
+func aacFor(chatID int64) (bool, float64) {
+	p := prefs.get(chatID)
+	enabled := conf.AAC
+	if p.AAC != nil {
+		enabled = *p.AAC
+	}
+	quality := conf.AACQuality
+	if p.AACQuality != nil {
+		quality = *p.AACQuality
+	}
+	return enabled, quality
+}
+
+// convertToAAC re-encodes filePath into an .m4a next to it and removes the
+// original, returning the new path.
+func convertToAAC(filePath string, kbps int, quality float64, job *activeJob) (string, error) {
+	outputPath := strings.TrimSuffix(filePath, filepath.Ext(filePath)) + ".m4a"
+	if outputPath == filePath {
+		outputPath = strings.TrimSuffix(filePath, ".m4a") + ".aac.m4a"
+	}
+
+	args := []string{"-i", filePath, "-vn", "-map_metadata", "0", "-c:a", "aac"}
+	if quality != 0 {
+		args = append(args, "-q:a", strconv.FormatFloat(quality, 'g', -1, 64))
+	} else {
+		args = append(args, "-b:a", fmt.Sprintf("%dk", kbps))
+	}
+	args = append(args, "-movflags", "+faststart", outputPath)
+
+	output, err := job.run(exec.Command("ffmpeg", args...))
+	if err != nil {
+		log.Printf("Error converting to AAC with ffmpeg: %s\n%s", err, string(output))
+		os.Remove(outputPath)
+		return "", fmt.Errorf("could not convert to AAC: %v", err)
+	}
+
+	os.Remove(filePath)
+	return outputPath, nil
+}
+
+func describeAAC(lang string, enabled bool, quality float64) string {
+	switch {
+	case !enabled:
+		return tr(lang, "aac.off")
+	case quality != 0:
+		return tr(lang, "aac.vbr", quality)
+	default:
+		return tr(lang, "aac.same_bitrate")
+	}
+}
+
+func handleAACSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+	usage := tr(langOf(message), "aac.usage", minAACQuality, maxAACQuality)
+
+	if arg == "" {
+		enabled, quality := aacFor(message.Chat.ID)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "aac.current", describeAAC(langOf(message), enabled, quality))+"\n\n"+usage)
+		return
+	}
+
+	enabled := true
+	quality := 0.0
+	switch arg {
+	case "on":
+	case "off":
+		enabled = false
+	default:
+		parsed, err := strconv.ParseFloat(arg, 64)
+		if err != nil || parsed == 0 || !isValidAACQuality(parsed) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "aac.bad_quality", minAACQuality, maxAACQuality))
+			return
+		}
+		quality = parsed
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.AAC = &enabled
+		p.AACQuality = &quality
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	sendText(bot, message.Chat.ID, tr(langOf(message), "aac.current", describeAAC(langOf(message), enabled, quality)))
+}
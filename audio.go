@@ -0,0 +1,427 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultReencodeMaxFactor = 1.6
+	minReencodeBitrateKbps   = 64
+)
+
+var standardBitrates = []int{320, 256, 192, 128, 96, 64}
+
+var validSampleRates = []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+
+func isValidSampleRate(rate int) bool {
+	if rate == 0 {
+		return true
+	}
+	for _, valid := range validSampleRates {
+		if rate == valid {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidChannels(channels int) bool {
+	return channels >= 0 && channels <= 2
+}
+
+func audioOptionsFor(chatID int64) (int, int) {
+	p := prefs.get(chatID)
+
+	sampleRate := conf.SampleRate
+	if p.SampleRate != 0 {
+		sampleRate = p.SampleRate
+	}
+	channels := conf.Channels
+	if p.Channels != 0 {
+		channels = p.Channels
+	}
+
+	return sampleRate, channels
+}
+
+func applyAudioOptions(filePath string, sampleRate int, channels int, kbps int, job *activeJob) error {
+	if sampleRate == 0 && channels == 0 {
+		return nil
+	}
+
+	ext := filepath.Ext(filePath)
+	outputPath := strings.TrimSuffix(filePath, ext) + ".conv" + ext
+	args := []string{"-i", filePath, "-vn", "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", kbps)}
+	if sampleRate != 0 {
+		args = append(args, "-ar", strconv.Itoa(sampleRate))
+	}
+	if channels != 0 {
+		args = append(args, "-ac", strconv.Itoa(channels))
+	}
+	args = append(args, outputPath)
+
+	output, err := job.run(exec.Command("ffmpeg", args...))
+	if err != nil {
+		log.Printf("Error converting file with ffmpeg: %s\n%s", err, string(output))
+		os.Remove(outputPath)
+		return fmt.Errorf("could not convert audio: %v", err)
+	}
+
+	return os.Rename(outputPath, filePath)
+}
+
+// processAudio applies the chat's audio settings to a fresh download in
+// place: sample rate and channels, silence trimming, the fade of a clipped
+// section and the playback speed. A step that fails is logged and left out.
+func processAudio(chatID int64, filePath string, kbps int, opts downloadOptions) error {
+	sampleRate, channels := audioOptionsFor(chatID)
+	if err := applyAudioOptions(filePath, sampleRate, channels, kbps, opts.Job); err != nil {
+		log.Println("Error converting mp3:", err)
+	}
+	if trimSilenceFor(chatID) {
+		if err := trimSilence(filePath, kbps, opts.Job); err != nil {
+			log.Println("Error trimming silence, sending untrimmed:", err)
+		}
+	}
+	if opts.Section != "" && fadeFor(chatID) {
+		if err := fadeClip(filePath, kbps, opts.Job); err != nil {
+			log.Println("Error fading clip, sending it unfaded:", err)
+		}
+	}
+	if speed := speedFor(chatID); speed != 1 {
+		if err := changeSpeed(filePath, kbps, speed, opts.Job); err != nil {
+			log.Println("Error changing speed, sending it at normal speed:", err)
+		}
+	}
+	if opts.Job.isCancelled() {
+		return errCancelled
+	}
+	return nil
+}
+
+// deliveryFormat converts a processed file to AAC when the chat asked for
+// it and returns the path of the file to send, which stays filePath if the
+// conversion fails.
+func deliveryFormat(chatID int64, filePath string, kbps int, opts downloadOptions) (string, error) {
+	aac, quality := aacFor(chatID)
+	if !aac {
+		return filePath, nil
+	}
+	m4aFilePath, err := convertToAAC(filePath, kbps, quality, opts.Job)
+	if opts.Job.isCancelled() {
+		return "", errCancelled
+	}
+	if err != nil {
+		log.Println("Error converting to AAC, sending mp3:", err)
+		return filePath, nil
+	}
+	return m4aFilePath, nil
+}
+
+// postProcess turns a fresh download into the file to send, the same way
+// on every download path. keep, if not nil, is handed the processed mp3
+// before it is converted to AAC.
+func postProcess(chatID int64, filePath string, kbps int, opts downloadOptions, keep func(string)) (string, error) {
+	if err := processAudio(chatID, filePath, kbps, opts); err != nil {
+		return "", err
+	}
+	if keep != nil {
+		keep(filePath)
+	}
+	return deliveryFormat(chatID, filePath, kbps, opts)
+}
+
+// encoderFor picks the ffmpeg encoder matching the container of filePath, so
+// re-encoded audio still fits the extension it is written under.
+func encoderFor(filePath string) string {
+	switch strings.ToLower(filepath.Ext(filePath)) {
+	case ".m4a", ".m4b", ".mp4", ".aac":
+		return "aac"
+	case ".opus", ".ogg", ".webm":
+		return "libopus"
+	default:
+		return "libmp3lame"
+	}
+}
+
+func handleAudioSettings(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+
+	if len(fields) == 0 {
+		sampleRate, channels := audioOptionsFor(message.Chat.ID)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "audio.current", describeSampleRate(langOf(message), sampleRate), describeChannels(langOf(message), channels))+"\n\n"+tr(langOf(message), "audio.usage"))
+		return
+	}
+
+	sampleRate := 0
+	if fields[0] != "source" {
+		rate, err := strconv.Atoi(fields[0])
+		if err != nil || rate == 0 || !isValidSampleRate(rate) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "audio.bad_sample_rate"))
+			return
+		}
+		sampleRate = rate
+	}
+
+	channels := 0
+	if len(fields) > 1 {
+		switch fields[1] {
+		case "mono", "1":
+			channels = 1
+		case "stereo", "2":
+			channels = 2
+		case "source":
+		default:
+			sendText(bot, message.Chat.ID, tr(langOf(message), "audio.bad_channels"))
+			return
+		}
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.SampleRate = sampleRate
+		p.Channels = channels
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	sampleRate, channels = audioOptionsFor(message.Chat.ID)
+	sendText(bot, message.Chat.ID, tr(langOf(message), "audio.current", describeSampleRate(langOf(message), sampleRate), describeChannels(langOf(message), channels)))
+}
+
+func describeSampleRate(lang string, rate int) string {
+	if rate == 0 {
+		return tr(lang, "audio.same_as_source")
+	}
+	return fmt.Sprintf("%d Hz", rate)
+}
+
+func describeChannels(lang string, channels int) string {
+	switch channels {
+	case 1:
+		return tr(lang, "audio.mono")
+	case 2:
+		return tr(lang, "audio.stereo")
+	default:
+		return tr(lang, "audio.same_as_source")
+	}
+}
+
+func probeDuration(filePath string) (float64, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("could not probe file: %v", err)
+	}
+
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse duration: %v", err)
+	}
+
+	return duration, nil
+}
+
+func probeBitrate(filePath string) (int64, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=bit_rate", "-of", "default=noprint_wrappers=1:nokey=1", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("could not probe file: %v", err)
+	}
+
+	bitrate, err := strconv.ParseInt(strings.TrimSpace(string(output)), 10, 64)
+	if err != nil || bitrate <= 0 {
+		return 0, fmt.Errorf("could not parse bitrate %q", strings.TrimSpace(string(output)))
+	}
+
+	return bitrate, nil
+}
+
+func fitBitrate(filePath string, size int64, maxKbps int) (int, bool) {
+	if float64(size) > float64(maxFileSize)*conf.ReencodeMaxFactor {
+		return 0, false
+	}
+
+	return requiredBitrate(filePath, maxKbps)
+}
+
+// budgetBitrate returns the highest bitrate at which duration seconds of
+// audio still fit under maxFileSize.
+func budgetBitrate(duration float64) int {
+	// Leave a little headroom for container overhead and tags.
+	budgetBits := float64(maxFileSize) * 8 * 0.97
+	return int(budgetBits / duration / 1000)
+}
+
+func requiredBitrate(filePath string, maxKbps int) (int, bool) {
+	duration, err := probeDuration(filePath)
+	if err != nil || duration <= 0 {
+		log.Println("Error probing duration:", err)
+		return 0, false
+	}
+
+	kbps := budgetBitrate(duration)
+	if kbps < minReencodeBitrateKbps {
+		return 0, false
+	}
+	if kbps > maxKbps {
+		kbps = maxKbps
+	}
+
+	return kbps, true
+}
+
+func reencodeFile(filePath string, kbps int, job *activeJob) error {
+	ext := filepath.Ext(filePath)
+	outputPath := strings.TrimSuffix(filePath, ext) + ".fit" + ext
+
+	cmd := exec.Command("ffmpeg", "-i", filePath, "-vn", "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", kbps), outputPath)
+	output, err := job.run(cmd)
+	if err != nil {
+		os.Remove(outputPath)
+		if errors.Is(err, errCancelled) {
+			return err
+		}
+		log.Printf("Error re-encoding file with ffmpeg: %s\n%s", err, string(output))
+		return fmt.Errorf("could not re-encode audio: %v", err)
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil || fileInfo.Size() > maxFileSize {
+		os.Remove(outputPath)
+		return fmt.Errorf("re-encoded file still exceeds the size limit")
+	}
+
+	return os.Rename(outputPath, filePath)
+}
+
+func isStandardBitrate(kbps int) bool {
+	for _, standard := range standardBitrates {
+		if kbps == standard {
+			return true
+		}
+	}
+	return false
+}
+
+func estimateSize(duration float64, kbps int) int64 {
+	return int64(duration * float64(kbps) * 1000 / 8)
+}
+
+var losslessCodecs = []string{"flac", "alac", "pcm", "wav"}
+
+func sourceBitrate(info *videoInfo) (int, bool) {
+	codec := strings.ToLower(info.ACodec)
+	abr := info.ABR
+	for _, format := range info.RequestedFormats {
+		if format.ACodec != "" && format.ACodec != "none" {
+			codec = strings.ToLower(format.ACodec)
+			abr = format.ABR
+		}
+	}
+
+	for _, lossless := range losslessCodecs {
+		if strings.HasPrefix(codec, lossless) {
+			return standardBitrates[0], true
+		}
+	}
+	if abr <= 0 {
+		return 0, false
+	}
+
+	return int(abr + 0.5), true
+}
+
+// selectBitrate returns the bitrate to encode at and a short note explaining
+// why it differs from the usual default, if it does.
+func selectBitrate(chatID int64, lang string, info *videoInfo) (int, string) {
+	if explicit := prefs.get(chatID).Bitrate; explicit != 0 {
+		return explicit, ""
+	}
+	if info == nil || info.Duration <= 0 {
+		return bitrateKBps, ""
+	}
+
+	sourceCap := standardBitrates[0]
+	if source, ok := sourceBitrate(info); ok {
+		sourceCap = highestStandardBitrate(func(kbps int) bool { return kbps <= source })
+	}
+	fitCap := highestStandardBitrate(func(kbps int) bool {
+		return estimateSize(info.Duration, kbps) <= maxFileSize*95/100
+	})
+
+	switch {
+	case fitCap < sourceCap:
+		return fitCap, tr(lang, "quality.note_fit")
+	case sourceCap < standardBitrates[0]:
+		return sourceCap, tr(lang, "quality.note_source")
+	default:
+		return sourceCap, ""
+	}
+}
+
+func highestStandardBitrate(ok func(kbps int) bool) int {
+	for _, kbps := range standardBitrates {
+		if ok(kbps) {
+			return kbps
+		}
+	}
+	return standardBitrates[len(standardBitrates)-1]
+}
+
+func bitrateCaption(meta trackMeta) string {
+	if meta.BitrateNote != "" {
+		return tr(meta.Lang, "bitrate.caption_note", meta.Bitrate, meta.BitrateNote)
+	}
+	return tr(meta.Lang, "settings.kbps", meta.Bitrate)
+}
+
+func handleQuality(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	arg := strings.TrimSpace(args)
+
+	if arg == "" {
+		current := prefs.get(message.Chat.ID).Bitrate
+		if current == 0 {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "quality.current_auto")+"\n\n"+tr(langOf(message), "quality.usage"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "quality.current", current)+"\n\n"+tr(langOf(message), "quality.usage"))
+		}
+		return
+	}
+
+	kbps := 0
+	if arg != "auto" {
+		parsed, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(arg), "k"))
+		if err != nil || !isStandardBitrate(parsed) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "quality.unsupported"))
+			return
+		}
+		kbps = parsed
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.Bitrate = kbps
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if kbps == 0 {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "quality.set_auto"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "quality.set", kbps))
+	}
+}
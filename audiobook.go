@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxAudiobookBitrateKbps  = 128
+	monoAudiobookBitrateKbps = 64
+	minAudiobookBitrateKbps  = 48
+)
+
+var ffmetadataEscaper = strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+
+func handleAudiobook(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	chatID := message.Chat.ID
+	lang := langOf(message)
+	url := strings.TrimSpace(args)
+	if !isPlaylistURL(url) {
+		replyText(bot, message, tr(lang, "audiobook.usage"))
+		return
+	}
+
+	playlist, err := fetchPlaylistInfo(url)
+	if err != nil {
+		replyText(bot, message, tr(lang, "playlist.read_failed", err))
+		return
+	}
+	if len(playlist.Entries) == 0 {
+		replyText(bot, message, tr(lang, "playlist.empty"))
+		return
+	}
+
+	limit := conf.MaxPlaylistItems
+	if limit <= 0 {
+		limit = defaultMaxPlaylistItems
+	}
+	if len(playlist.Entries) > limit {
+		replyText(bot, message, trn(lang, "audiobook.too_many", len(playlist.Entries), limit))
+		return
+	}
+
+	var total float64
+	for _, entry := range playlist.Entries {
+		total += entry.Duration
+	}
+	if total > 0 && budgetBitrate(total) < minAudiobookBitrateKbps {
+		replyText(bot, message, tr(lang, "audiobook.too_long", formatDuration(int(total)), minAudiobookBitrateKbps))
+		return
+	}
+
+	reason, refund := takeQuotaFor(message, len(playlist.Entries))
+	if reason != "" {
+		replyText(bot, message, reason)
+		return
+	}
+
+	job := newQueuedJob(message, url, &videoInfo{Title: playlist.Title})
+	reportQueuePosition(bot, message, job, downloads.enqueue(job))
+	if !downloads.wait(job) {
+		refund()
+		return
+	}
+	defer downloads.release(job)
+
+	active := startActiveJob(message)
+	defer active.finish()
+	defer replyWithCancel(bot, message, trn(lang, "audiobook.building", len(playlist.Entries), playlist.Title), active)()
+	cancelled := func() bool {
+		if !active.isCancelled() {
+			return false
+		}
+		log.Printf("Audiobook %s was cancelled", url)
+		sendText(bot, chatID, tr(lang, "download.cancelled"))
+		return true
+	}
+
+	if reason := checkDiskSpace(bot, lang, &videoInfo{Duration: total}, bitrateKBps); reason != "" {
+		sendText(bot, chatID, reason)
+		return
+	}
+
+	dir, err := newJobDir(chatID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		sendText(bot, chatID, tr(lang, "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+	// The downloads and the audiobook built from them are on disk together.
+	if !diskUsage.reserve(dir, requiredDiskSpace(&videoInfo{Duration: total}, bitrateKBps)+maxFileSize) {
+		log.Printf("Rejecting audiobook %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+		sendText(bot, chatID, tr(lang, "download.no_disk_space"))
+		return
+	}
+
+	var tracks []string
+	var titles []string
+
+	opts := downloadOptions{Job: active, Files: newFileBudget()}
+	for i, entry := range playlist.Entries {
+		if cancelled() {
+			return
+		}
+		if info, _ := checkEntry(bot, chatID, lang, entry, playlist); info == nil {
+			continue
+		}
+		mp3FilePath, err := downloadMp3(entry.watchURL(), filepath.Join(dir, fmt.Sprintf("part_%03d", i)), bitrateKBps, opts)
+		if err == nil {
+			// The audiobook is AAC anyway, so only the audio settings apply.
+			err = processAudio(chatID, mp3FilePath, bitrateKBps, opts)
+		}
+		if errors.Is(err, errCancelled) {
+			cancelled()
+			return
+		}
+		if errors.Is(err, errTooManyFiles) {
+			log.Printf("Stopping audiobook %s after %d files", url, maxOutputFiles())
+			sendText(bot, chatID, userErrorMessage(lang, err))
+			return
+		}
+		if err != nil {
+			log.Printf("Error downloading audiobook part %s: %v", entry.ID, err)
+			sendText(bot, chatID, tr(lang, "track.skipping", entry.Title, userErrorMessage(lang, err)))
+			continue
+		}
+		tracks = append(tracks, mp3FilePath)
+		titles = append(titles, entry.Title)
+	}
+
+	if len(tracks) == 0 {
+		sendText(bot, chatID, tr(lang, "playlist.none_downloaded"))
+		return
+	}
+
+	bookPath := filepath.Join(dir, sanitizeFilename(playlist.Title)+".m4b")
+
+	kbps, err := buildAudiobook(lang, tracks, titles, playlist, bookPath, active)
+	if cancelled() {
+		return
+	}
+	if err != nil {
+		log.Println("Error building audiobook:", err)
+		sendText(bot, chatID, tr(lang, "audiobook.build_failed", err))
+		return
+	}
+
+	if !active.commit() {
+		cancelled()
+		return
+	}
+	stopAction := startChatAction(bot, chatID, tgbotapi.ChatUploadDocument)
+	defer stopAction()
+	_, err = sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+		file, err := os.Open(bookPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: sanitizeFilename(playlist.Title) + ".m4b", Reader: file})
+		doc.Caption = truncateUTF16(playlist.Title+"\n"+trn(lang, "audiobook.caption", len(tracks), kbps)+"\n"+url, maxCaptionLength)
+		return doc, func() { file.Close() }, nil
+	})
+	if err != nil {
+		log.Println("Error sending audiobook:", err)
+		sendText(bot, chatID, tr(lang, "audiobook.send_failed", err))
+	}
+}
+
+// buildAudiobook encodes the tracks into a single AAC file with one chapter
+// per track, at the highest bitrate that fits, and returns that bitrate. Its
+// errors are shown to the user, so they are in lang.
+func buildAudiobook(lang string, tracks []string, titles []string, playlist *playlistInfo, outputPath string, job *activeJob) (int, error) {
+	var sb strings.Builder
+	sb.WriteString(";FFMETADATA1\n")
+	fmt.Fprintf(&sb, "title=%s\n", ffmetadataEscaper.Replace(playlist.Title))
+	fmt.Fprintf(&sb, "album=%s\n", ffmetadataEscaper.Replace(playlist.Title))
+	if playlist.Uploader != "" {
+		fmt.Fprintf(&sb, "artist=%s\n", ffmetadataEscaper.Replace(playlist.Uploader))
+	}
+	sb.WriteString("genre=Audiobook\n")
+
+	var position float64
+	for i, track := range tracks {
+		duration, err := probeDuration(track)
+		if err != nil {
+			return 0, err
+		}
+		fmt.Fprintf(&sb, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n", int64(position*1000), int64((position+duration)*1000), ffmetadataEscaper.Replace(titles[i]))
+		position += duration
+	}
+
+	kbps := budgetBitrate(position)
+	if kbps > maxAudiobookBitrateKbps {
+		kbps = maxAudiobookBitrateKbps
+	}
+	if kbps < minAudiobookBitrateKbps {
+		return 0, errors.New(tr(lang, "audiobook.too_long", formatDuration(int(position)), minAudiobookBitrateKbps))
+	}
+
+	metadataPath := outputPath + ".meta"
+	listPath := outputPath + ".txt"
+	defer removeTempFile(metadataPath)
+	defer removeTempFile(listPath)
+
+	if err := os.WriteFile(metadataPath, []byte(sb.String()), 0644); err != nil {
+		return 0, errors.New(tr(lang, "audiobook.metadata_failed", err))
+	}
+
+	var list strings.Builder
+	for _, track := range tracks {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(track, "'", `'\''`))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return 0, errors.New(tr(lang, "audiobook.list_failed", err))
+	}
+
+	args := []string{"-f", "concat", "-safe", "0", "-i", listPath, "-i", metadataPath, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1", "-c:a", "aac", "-b:a", fmt.Sprintf("%dk", kbps)}
+	if kbps < monoAudiobookBitrateKbps {
+		args = append(args, "-ac", "1")
+	}
+	args = append(args, "-f", "mp4", outputPath)
+
+	output, err := job.run(exec.Command("ffmpeg", args...))
+	if err != nil {
+		log.Printf("Error building audiobook with ffmpeg: %s\n%s", err, string(output))
+		return 0, err
+	}
+
+	fileInfo, err := os.Stat(outputPath)
+	if err != nil {
+		return 0, err
+	}
+	if fileInfo.Size() > maxFileSize {
+		return 0, errors.New(tr(lang, "audiobook.over_limit", formatSize(fileInfo.Size())))
+	}
+
+	return kbps, nil
+}
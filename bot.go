@@ -1,13 +1,23 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"embed"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	neturl "net/url"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -20,20 +30,153 @@ var (
 	bitrateKBps       = 128
 )
 
+const (
+	maxSplitAttempts         = 4
+	defaultConcurrentUploads = 2
+
+	// maxMessageLength is far more than any link plus keywords needs;
+	// longer messages are rejected before anything is parsed.
+	maxMessageLength = 2048
+)
+
+var splitSlots = make(chan struct{}, 1)
+
+// Files are named after the video so they save with a meaningful name; the
+// ID keeps them unique and the title is capped well below path limits.
+const defaultOutputTemplate = "%(title).100B [%(id)s].%(ext)s"
+
+var (
+	conf  *Config
+	prefs *prefsStore
+)
+
 type Config struct {
-	BotToken  string `json:"bot-token"`
-	DebugMode bool   `json:"debug-mode"`
+	BotToken      string `json:"bot-token"`
+	DebugMode     bool   `json:"debug-mode"`
+	KeepTempFiles bool   `json:"keep-temp-files"`
+	PrefsFile     string `json:"prefs-file"`
+	CacheFile     string `json:"cache-file"`
+	HealthPort    int    `json:"health-port"`
+
+	// APIEndpoint is the base URL of a local Bot API server, such as
+	// http://localhost:8081; the public Bot API is used when it's empty.
+	APIEndpoint string `json:"api-endpoint"`
+
+	WhisperPath              string `json:"whisper-path"`
+	WhisperModel             string `json:"whisper-model"`
+	TranscribeTimeoutMinutes int    `json:"transcribe-timeout-minutes"`
+
+	MaxDurationMinutes int  `json:"max-duration-minutes"`
+	AutoStart          bool `json:"auto-start"`
+
+	SampleRate int `json:"sample-rate"`
+	Channels   int `json:"channels"`
+
+	ReencodeMaxFactor float64 `json:"reencode-max-factor"`
+
+	MaxConcurrentDownloads int `json:"max-concurrent-downloads"`
+
+	SplitOnSilence      bool `json:"split-on-silence"`
+	AccurateSplit       bool `json:"accurate-split"`
+	MaxConcurrentSplits int  `json:"max-concurrent-splits"`
+	ConcurrentUploads   int  `json:"concurrent-uploads"`
+
+	PreferDocument    bool `json:"prefer-document"`
+	MaxDocumentSizeMB int  `json:"max-document-size-mb"`
+
+	// The trim and fade settings are pointers so that an explicit 0 is
+	// used as given rather than replaced by the default.
+	TrimSilence            bool     `json:"trim-silence"`
+	TrimSilenceThresholdDB *int     `json:"trim-silence-threshold-db"`
+	TrimSilenceKeepSeconds *float64 `json:"trim-silence-keep-seconds"`
+
+	FadeClips   bool     `json:"fade-clips"`
+	FadeSeconds *float64 `json:"fade-seconds"`
+
+	AAC        bool    `json:"aac"`
+	AACQuality float64 `json:"aac-quality"`
+
+	Speed float64 `json:"speed"`
+
+	DailyQuota  int     `json:"daily-quota"`
+	AdminIDs    []int64 `json:"admin-ids"`
+	AdminChatID int64   `json:"admin-chat-id"`
+
+	CookiesFile string `json:"cookies-file"`
+	SocialSites bool   `json:"social-sites"`
+	Proxy       string `json:"proxy"`
+
+	MaxPlaylistItems int  `json:"max-playlist-items"`
+	MaxOutputFiles   int  `json:"max-output-files"`
+	AutoPlaylist     bool `json:"auto-playlist"`
+
+	OutputTemplate  string `json:"output-template"`
+	CaptionStyle    string `json:"caption-style"`
+	CaptionTemplate string `json:"caption-template"`
+	StatusSummary   bool   `json:"status-summary"`
+
+	ReplyToRequests bool   `json:"reply-to-requests"`
+	GroupGreeting   string `json:"group-greeting"`
+	AckStyle        string `json:"ack-style"`
+
+	DownloadDir         string `json:"download-dir"`
+	MaxDiskUsageMB      int    `json:"max-disk-usage-mb"`
+	ReapIntervalMinutes int    `json:"reap-interval-minutes"`
+	ReapMaxAgeMinutes   int    `json:"reap-max-age-minutes"`
+	ConvertedCacheFiles int    `json:"converted-cache-files"`
+
+	LogFile      string `json:"log-file"`
+	LogMaxSizeMB int    `json:"log-max-size-mb"`
+	// LogMaxBackups is a pointer so that an explicit 0, keeping no
+	// backups, can be told from the key being left out.
+	LogMaxBackups *int `json:"log-max-backups"`
 }
 
 func main() {
-	conf, err := loadConfig()
+	var err error
+	conf, err = loadConfig()
 	if err != nil {
-		panic(fmt.Errorf("error loading configuration: %v", err))
+		log.Fatalf("config error: %v", err)
+	}
+
+	if conf.LogFile != "" {
+		logFile, err := openRotatingFile(conf.LogFile, int64(conf.LogMaxSizeMB)*1024*1024, *conf.LogMaxBackups)
+		if err != nil {
+			log.Fatalf("config error: log-file %q: %v", conf.LogFile, err)
+		}
+		log.SetOutput(io.MultiWriter(os.Stderr, logFile))
+	}
+
+	if conf.Proxy != "" {
+		log.Println("Using proxy for yt-dlp requests")
+	} else {
+		log.Println("No proxy configured for yt-dlp requests")
+	}
+
+	if err := prepareDownloadDir(conf.DownloadDir); err != nil {
+		log.Fatalf("config error: download-dir %q: %v", conf.DownloadDir, err)
+	}
+
+	if keepTempFiles() {
+		log.Println("Debug mode: keeping temp files in", conf.DownloadDir)
 	}
+	interrupted := findInterruptedJobs(conf.DownloadDir, time.Duration(conf.ReapMaxAgeMinutes)*time.Minute)
+	startReaper(conf.DownloadDir, time.Duration(conf.ReapIntervalMinutes)*time.Minute, time.Duration(conf.ReapMaxAgeMinutes)*time.Minute)
+
+	if conf.MaxConcurrentDownloads > 0 {
+		downloads.limit = conf.MaxConcurrentDownloads
+	}
+	splitSlots = make(chan struct{}, conf.MaxConcurrentSplits)
+
+	prefs, err = loadPrefs(conf.PrefsFile)
+	if err != nil {
+		log.Fatalf("error loading preferences from %s: %v", conf.PrefsFile, err)
+	}
+	uploadCache.path = conf.CacheFile
 	botToken := conf.BotToken
 	fmt.Println("Bot token:", botToken)
 
-	bot, err := tgbotapi.NewBotAPI(botToken)
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(botToken, apiEndpoint(conf.APIEndpoint))
 	if err != nil {
 		log.Panic(err)
 	}
@@ -41,134 +184,785 @@ func main() {
 	bot.Debug = conf.DebugMode
 
 	log.Printf("Authorized on account %s", bot.Self.UserName)
+	registerCommands(bot)
 
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 60
+	if conf.HealthPort > 0 {
+		go startHealthServer(bot, conf.HealthPort)
+	}
 
-	updates := bot.GetUpdatesChan(u)
+	resumeJobs(bot, interrupted)
 
-	for update := range updates {
+	pollUpdates(bot, func(update tgbotapi.Update) {
 		if update.Message != nil {
 			go handleMessage(bot, update.Message)
 		}
-	}
+		if update.CallbackQuery != nil {
+			go handleCallback(bot, update.CallbackQuery)
+		}
+	})
 }
 
 func handleMessage(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
-	url := strings.TrimSpace(message.Text)
+	rememberLanguage(message.From)
+
+	// In groups the bot only speaks up for links, commands and mentions, so
+	// the rest of the conversation goes by without replies.
+	group := isGroupChat(message.Chat)
+	if group && addedToChat(bot, message) {
+		greetGroup(bot, message)
+		return
+	}
 
-	if !isValidYouTubeURL(url) {
-		msg := tgbotapi.NewMessage(message.Chat.ID, "Please send a valid YouTube video URL.")
-		_, err := bot.Send(msg)
+	if len(message.Text) > maxMessageLength {
+		if !group {
+			replyText(bot, message, tr(langOf(message), "request.not_url"))
+		}
+		return
+	}
+
+	if message.IsCommand() {
+		if group && isForOtherBot(bot, message) {
+			return
+		}
+		handleCommand(bot, message)
+		return
+	}
+
+	text, mentioned := message.Text, false
+	if group {
+		text, mentioned = groupRequest(bot, message.Text)
+	}
+	url, opts := parseRequest(message.Chat.ID, text)
+
+	if opts.Playlist && (isValidYouTubeURL(url) || isPlaylistURL(url)) {
+		if reason := checkQuota(message); reason != "" {
+			replyText(bot, message, reason)
+			return
+		}
+		processPlaylist(bot, message, url, opts)
+		return
+	}
+
+	if !isSupportedURL(url) {
+		if group && !mentioned {
+			return
+		}
+		msg := tgbotapi.NewMessage(message.Chat.ID, tr(langOf(message), "request.invalid_url", supportedSitesText(langOf(message))))
+		msg.ReplyToMessageID = replyTarget(message)
+		_, err := sendMessage(bot, msg)
 		if err != nil {
 			log.Println("Error sending message:", err)
 		}
 		return
 	}
 
-	msg := tgbotapi.NewMessage(message.Chat.ID, "Starting to process your request...")
-	_, err := bot.Send(msg)
-	if err != nil {
-		log.Println("Error sending message:", err)
+	if isPlaylistURL(url) {
+		replyText(bot, message, tr(langOf(message), "request.playlist_link"))
+	}
+
+	if reason := checkQuota(message); reason != "" {
+		replyText(bot, message, reason)
+		return
 	}
 
-	mp3FilePath, m4aFilePath, err := downloadMp3(url, message.Chat.ID)
+	// Always look the video up first: a live stream would otherwise keep
+	// yt-dlp recording forever.
+	info, err := fetchVideoInfo(url)
 	if err != nil {
-		errorMsg := tgbotapi.NewMessage(message.Chat.ID, "Error downloading mp3: "+err.Error())
-		_, err = bot.Send(errorMsg)
+		replyText(bot, message, userErrorMessage(langOf(message), err))
+		return
+	}
+	if info.IsLive {
+		askLiveClip(bot, message, url, info, opts)
+		return
+	}
+	if reason := checkDurationLimit(langOf(message), info); reason != "" {
+		replyText(bot, message, reason)
+		return
+	}
+	if !conf.AutoStart {
+		askConfirmation(bot, message, url, info, opts)
+		return
+	}
+
+	processDownload(bot, message, url, info, opts)
+}
+
+// isRequestKeyword reports whether word is one of the keywords parseRequest
+// understands after a link.
+func isRequestKeyword(word string) bool {
+	switch strings.ToLower(word) {
+	case "zip", "playlist", "merge", "force":
+		return true
+	}
+	return false
+}
+
+func parseRequest(chatID int64, text string) (string, downloadOptions) {
+	opts := downloadOptions{Zip: prefs.get(chatID).Zip}
+
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return "", opts
+	}
+
+	for _, keyword := range fields[1:] {
+		switch strings.ToLower(keyword) {
+		case "zip":
+			opts.Zip = true
+		case "playlist":
+			opts.Playlist = true
+		case "merge":
+			opts.Playlist = true
+			opts.Merge = true
+		case "force":
+			opts.Force = true
+		}
+	}
+	if !opts.Playlist && isPlaylistURL(fields[0]) && autoPlaylistFor(chatID) {
+		opts.Playlist = true
+	}
+
+	return fields[0], opts
+}
+
+func processDownload(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string, info *videoInfo, opts downloadOptions) {
+	// A resumed download was already counted before the restart.
+	refund := func() {}
+	if !opts.Resumed {
+		var reason string
+		if reason, refund = takeQuota(message); reason != "" {
+			replyText(bot, message, reason)
+			return
+		}
+	}
+
+	setReaction(bot, message, reactionAccepted)
+	job := newQueuedJob(message, url, info)
+	reportQueuePosition(bot, message, job, downloads.enqueue(job))
+	if !downloads.wait(job) {
+		refund()
+		return
+	}
+	defer downloads.release(job)
+
+	active := startActiveJob(message)
+	defer active.finish()
+	opts.Job = active
+	opts.Slot = job
+	if opts.Files == nil {
+		opts.Files = newFileBudget()
+	}
+
+	var status tgbotapi.Message
+	var keyboard *tgbotapi.InlineKeyboardMarkup
+	var err error
+	if !reactionAcks() {
+		keyboard = cancelKeyboard(active)
+		if opts.StatusID != 0 {
+			// The old Cancel button points at a job from before the restart.
+			edit := tgbotapi.NewEditMessageText(message.Chat.ID, opts.StatusID, jobState{}.text(langOf(message)))
+			edit.ReplyMarkup = keyboard
+			status, err = sendMessage(bot, edit)
+			switch {
+			case err != nil && strings.Contains(err.Error(), "message is not modified"):
+				status.MessageID = opts.StatusID
+			case err != nil:
+				log.Println("Error reusing status message, sending a new one:", err)
+			}
+		}
+		if status.MessageID == 0 {
+			msg := tgbotapi.NewMessage(message.Chat.ID, jobState{}.text(langOf(message)))
+			msg.ReplyToMessageID = replyTarget(message)
+			msg.ReplyMarkup = keyboard
+			status, err = sendMessage(bot, msg)
+			if err != nil {
+				log.Println("Error sending message:", err)
+			}
+		}
+	}
+	opts.StatusID = status.MessageID
+	editor := newStatusEditor(bot, message.Chat.ID, langOf(message), status.MessageID, keyboard)
+	defer editor.close()
+	// Failures are shown in the status message, so the chat keeps one
+	// message per request.
+	fail := func(text string) {
+		setReaction(bot, message, reactionFailed)
+		if !editor.replace(text) {
+			replyText(bot, message, text)
+		}
+	}
+	done := func() {
+		editor.finish()
+		setReaction(bot, message, reactionDone)
+	}
+	opts.Progress = editor.progress()
+	opts.Uploading = editor.uploading
+
+	if info == nil {
+		info, err = fetchVideoInfo(url)
 		if err != nil {
-			log.Println("Error sending message:", err)
+			log.Println("Error fetching video info, captions will be incomplete:", err)
 		}
-		log.Println("Error downloading mp3:", err)
+	}
+
+	kbps, bitrateNote := selectBitrate(message.Chat.ID, langOf(message), info)
+	if opts.FormatID != "" {
+		if format, ok := findFormat(info, opts.FormatID); ok && format.ABR > 0 {
+			kbps = int(format.ABR + 0.5)
+		}
+		bitrateNote = tr(langOf(message), "quality.note_format", opts.FormatID)
+	}
+	// Parts already on disk were encoded at the bitrate the job started
+	// with, even if the settings changed since.
+	if opts.Bitrate != 0 && opts.Bitrate != kbps {
+		kbps, bitrateNote = opts.Bitrate, ""
+	}
+	opts.Bitrate = kbps
+
+	cacheKey := downloadKey(message.Chat.ID, info, kbps, opts)
+	// The parts of a cached split upload that went out before one failed.
+	var resent trackMeta
+	var resentParts int
+	if cached, ok := cachedUploadFor(cacheKey); ok && !opts.Force && !(opts.Zip && len(cached.FileIDs) > 1) && active.commit() {
+		meta := newTrackMeta(url, info, kbps, langOf(message))
+		meta.BitrateNote = bitrateNote
+		meta.ReplyTo = replyTarget(message)
+		meta.CacheKey = cacheKey
+		editor.uploading(0, 0, cached.Size)
+		sent, err := sendCachedUpload(bot, message.Chat.ID, cached, meta)
+		if err == nil {
+			done()
+			recordHistory(message, cacheKey, meta)
+			recordStats(message, url, cached.Size)
+			return
+		}
+		log.Println("Error sending cached upload, downloading again:", err)
+		resent, resentParts = meta, sent
+		resent.Parts = len(cached.FileIDs)
+	}
+
+	if reason := checkDiskSpace(bot, langOf(message), info, kbps); reason != "" {
+		fail(reason)
 		return
 	}
 
-	err = checkAndSendFile(mp3FilePath, message.Chat.ID, bot)
+	var dir string
+	if opts.LiveClip > 0 {
+		// A clip recorded after a restart would be a different clip, so
+		// live recordings aren't resumable.
+		dir, err = newJobDir(message.Chat.ID)
+	} else {
+		dir, err = jobDirFor(message, url, opts)
+	}
 	if err != nil {
-		errorMsg := tgbotapi.NewMessage(message.Chat.ID, "Error sending mp3: "+err.Error())
-		_, err = bot.Send(errorMsg)
+		log.Println("Error creating job directory:", err)
+		fail(tr(langOf(message), "download.prepare_failed", err))
+		return
+	}
+	retained := false
+	defer func() {
+		if !retained {
+			removeJobDir(dir)
+		}
+	}()
+
+	if !diskUsage.reserve(dir, requiredDiskSpace(info, kbps)) {
+		log.Printf("Rejecting download, it would go over max-disk-usage-mb of %d", conf.MaxDiskUsageMB)
+		fail(tr(langOf(message), "download.no_disk_space"))
+		return
+	}
+	if resentParts > 0 {
+		// The new split skips them as long as it comes out the same.
+		record := loadSentParts(dir, resent)
+		for part := 1; part <= resentParts; part++ {
+			record.mark(part)
+		}
+	}
+
+	convKey := convertedKey(message.Chat.ID, info, opts)
+	mp3FilePath, shared, err := shareDownload(cacheKey, dir, active, opts.Progress, func(progress func(downloadProgress)) (string, error) {
+		if opts.Resumed {
+			if path, ok := processedFile(dir); ok {
+				log.Println("Resumed job was already processed, sending", filepath.Base(path))
+				return path, nil
+			}
+		}
+		// The cache holds the processed mp3, before any AAC conversion.
+		if path, ok := convertedFiles.take(convKey, kbps, dir); ok {
+			return deliveryFormat(message.Chat.ID, path, kbps, opts)
+		}
+
+		opts := opts
+		opts.Progress = progress
+		mp3FilePath, err := downloadMp3(url, dir, kbps, opts)
 		if err != nil {
-			log.Println("Error sending message:", err)
+			return "", err
 		}
+		if opts.Job.isCancelled() {
+			return "", errCancelled
+		}
+		return postProcess(message.Chat.ID, mp3FilePath, kbps, opts, func(path string) {
+			convertedFiles.put(convKey, path, kbps)
+		})
+	})
+	if shared {
+		log.Printf("Reused an in-flight download of %s", url)
+	}
+	if err == nil {
+		markProcessed(dir, mp3FilePath)
+	}
+	if active.isCancelled() {
+		log.Printf("Download of %s was cancelled", url)
+		fail(tr(langOf(message), "download.cancelled"))
+		return
+	}
+	if err != nil {
+		log.Println("Error downloading mp3:", err)
+		fail(userErrorMessage(langOf(message), err))
+		return
+	}
+
+	removeIntermediates(dir, mp3FilePath)
+
+	meta := newTrackMeta(url, info, kbps, langOf(message))
+	meta.BitrateNote = bitrateNote
+	meta.ReplyTo = replyTarget(message)
+	meta.CacheKey = cacheKey
+	var size int64
+	if fileInfo, err := os.Stat(mp3FilePath); err == nil {
+		size = fileInfo.Size()
+	}
+	if !opts.Resumed {
+		resetUpload(cacheKey)
+	}
+	// Deciding what to do with an oversized file, re-encoding and splitting
+	// it can still be cancelled; only the upload itself can't.
+	opts.Commit = true
+	err = checkAndSendFile(mp3FilePath, message.Chat.ID, bot, meta, opts)
+	if errors.Is(err, errCancelled) {
+		log.Printf("Download of %s was cancelled", url)
+		fail(tr(langOf(message), "download.cancelled"))
+		return
+	}
+	if errors.Is(err, errTooManyFiles) {
+		fail(userErrorMessage(langOf(message), err))
+		return
+	}
+	if err != nil {
 		log.Println("Error sending mp3:", err)
+		text := tr(langOf(message), "download.send_failed", err)
+		var uploadErr *uploadError
+		if errors.As(err, &uploadErr) {
+			retainUpload(message.Chat.ID, dir, uploadErr.Remaining)
+			retained = true
+			text += "\n" + trn(langOf(message), "download.kept_for_retry", int(retainUploadFor.Minutes()))
+		}
+		fail(text)
+		return
+	}
+	done()
+	recordHistory(message, cacheKey, meta)
+	recordStats(message, url, size)
+}
+
+func checkDurationLimit(lang string, info *videoInfo) string {
+	limit := conf.MaxDurationMinutes * 60
+	if limit <= 0 {
+		return ""
 	}
 
-	os.Remove(m4aFilePath)
+	if info.IsLive || info.Duration <= 0 {
+		return tr(lang, "duration.unknown", formatDuration(limit))
+	}
+	if int(info.Duration) > limit {
+		return tr(lang, "duration.too_long", formatDuration(int(info.Duration)), formatDuration(limit))
+	}
+
+	return ""
 }
 
-func sendFile(bot *tgbotapi.BotAPI, filePath string, chatID int64) error {
-	audioFile := tgbotapi.NewAudio(chatID, tgbotapi.FilePath(filePath))
-	_, err := bot.Send(audioFile)
-	os.Remove(filePath)
+func sendFile(bot *tgbotapi.BotAPI, filePath string, chatID int64, meta trackMeta) error {
+	duration := meta.audioDuration(filePath)
+	stopAction := startChatAction(bot, chatID, tgbotapi.ChatUploadVoice)
+	defer stopAction()
+	sent, err := sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+		// Hand the library an open file so the multipart body is streamed
+		// from disk rather than buffered; reopened on every attempt since a
+		// failed upload leaves the reader consumed.
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open file: %v", err)
+		}
+
+		audioFile := tgbotapi.NewAudio(chatID, tgbotapi.FileReader{Name: filepath.Base(filePath), Reader: file})
+		audioFile.Caption, audioFile.ParseMode = meta.captionFor(duration, fileSize(filePath))
+		audioFile.Title = meta.audioTitle()
+		audioFile.Performer = meta.Uploader
+		audioFile.Duration = duration
+		audioFile.ReplyToMessageID = meta.ReplyTo
+		return audioFile, func() { file.Close() }, nil
+	})
+	if err != nil {
+		return err
+	}
+	rememberUpload(meta, sent)
 
-	return err
+	removeTempFile(filePath)
+	return nil
 }
 
-func checkAndSendFile(filePath string, chatID int64, bot *tgbotapi.BotAPI) error {
+func checkAndSendFile(filePath string, chatID int64, bot *tgbotapi.BotAPI, meta trackMeta, opts downloadOptions) error {
+	defer flushUploadCache()
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return fmt.Errorf("could not check file size: %v", err)
 	}
 
 	if fileInfo.Size() > maxFileSize {
+		fitKbps, ok, err := chooseOversizeStrategy(bot, chatID, filePath, fileInfo.Size(), meta, opts)
+		if err != nil {
+			return err
+		}
+		if ok {
+			log.Printf("File exceeds 50 MB, re-encoding at %d kbps to fit", fitKbps)
+			err := reencodeFile(filePath, fitKbps, opts.Job)
+			if errors.Is(err, errCancelled) {
+				return err
+			}
+			if err == nil {
+				if !opts.beginUpload() {
+					return errCancelled
+				}
+				meta.Note = tr(meta.Lang, "upload.reencoded", fitKbps)
+				if fitInfo, err := os.Stat(filePath); err == nil {
+					opts.uploading(0, 0, fitInfo.Size())
+				}
+				if err := sendFile(bot, filePath, chatID, meta); err != nil {
+					return &uploadError{Remaining: []pendingUpload{{path: filePath, meta: meta}}, Err: err}
+				}
+				return nil
+			}
+			log.Println("Error re-encoding file, falling back to splitting:", err)
+		}
+
+		if canSendAsDocument(fileInfo.Size()) {
+			if !opts.beginUpload() {
+				return errCancelled
+			}
+			log.Println("File exceeds 50 MB, sending it as a document")
+			meta.Note = bitrateCaption(meta)
+			opts.uploading(0, 0, fileInfo.Size())
+			err := sendDocument(bot, filePath, chatID, meta)
+			if err == nil {
+				return nil
+			}
+			log.Println("Error sending document, falling back to splitting:", err)
+		}
+
 		log.Println("File exceeds 50 MB, splitting into parts")
-		partFiles, err := splitFile(filePath, maxFileSize, bitrateKBps)
+		partFiles, err := splitFile(filePath, maxFileSize, meta.Bitrate, opts.Job)
+		if errors.Is(err, errCancelled) {
+			return err
+		}
 		if err != nil {
 			return fmt.Errorf("error splitting file: %v", err)
 		}
+		if err := opts.Files.take(len(partFiles)); err != nil {
+			removeFiles(partFiles)
+			return err
+		}
 
-		for _, part := range partFiles {
-			err := sendFile(bot, part, chatID)
-			if err != nil {
-				return fmt.Errorf("error sending file part: %v", err)
-			}
+		meta.Note = bitrateCaption(meta)
+		meta.Parts = len(partFiles)
+
+		if !opts.beginUpload() {
+			removeFiles(partFiles)
+			return errCancelled
 		}
+		// Zip mode leaves split parts alone: together they are the file that
+		// was too large to send, so no archive of them would fit either.
+		return sendParts(bot, chatID, partFiles, meta, opts)
 	} else {
-		err := sendFile(bot, filePath, chatID)
+		if !opts.beginUpload() {
+			return errCancelled
+		}
+		meta.Note = bitrateCaption(meta)
+		opts.uploading(0, 0, fileInfo.Size())
+		err := sendFile(bot, filePath, chatID, meta)
 		if err != nil {
-			return fmt.Errorf("error sending file: %v", err)
+			return &uploadError{Remaining: []pendingUpload{{path: filePath, meta: meta}}, Err: err}
 		}
 	}
 
 	return nil
 }
 
-func splitFile(filePath string, chunkSize int64, bitrateKbps int) ([]string, error) {
-	var partFiles []string
+// sendParts uploads the parts a few at a time. They may arrive out of order,
+// but each caption says which part it is; any part that fails is reported
+// back so /retry can send just the missing ones.
+func sendParts(bot *tgbotapi.BotAPI, chatID int64, partFiles []string, meta trackMeta, opts downloadOptions) error {
+	workers := conf.ConcurrentUploads
+	if workers <= 0 {
+		workers = defaultConcurrentUploads
+	}
+
+	sent := loadSentParts(filepath.Dir(partFiles[0]), meta)
+	errs := make([]error, len(partFiles))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, len(partFiles)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				if sent.has(i + 1) {
+					log.Printf("Part %d/%d was already sent, skipping it", i+1, len(partFiles))
+					continue
+				}
+				partMeta := meta
+				partMeta.Part = i + 1
+				if partInfo, err := os.Stat(partFiles[i]); err == nil {
+					opts.uploading(i+1, len(partFiles), partInfo.Size())
+				}
+				errs[i] = sendFile(bot, partFiles[i], chatID, partMeta)
+				if errs[i] == nil {
+					sent.mark(i + 1)
+				}
+			}
+		}()
+	}
+	for i := range partFiles {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	uploadErr := &uploadError{}
+	var missing []string
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		log.Printf("Error sending part %d/%d: %v", i+1, len(partFiles), err)
+		partMeta := meta
+		partMeta.Part = i + 1
+		uploadErr.Remaining = append(uploadErr.Remaining, pendingUpload{path: partFiles[i], meta: partMeta})
+		missing = append(missing, strconv.Itoa(i+1))
+		if uploadErr.Err == nil {
+			uploadErr.Err = err
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	uploadErr.Err = fmt.Errorf("part %s of %d not sent: %v", strings.Join(missing, ", "), len(partFiles), uploadErr.Err)
+	return uploadErr
+}
+
+func splitFile(filePath string, chunkSize int64, bitrateKbps int, job *activeJob) ([]string, error) {
+	// Splitting is CPU-bound, unlike downloading, so it gets its own limit.
+	splitSlots <- struct{}{}
+	defer func() { <-splitSlots }()
 
-	segmentTime := calculateSegmentTime(chunkSize, bitrateKbps)
-	log.Printf("Splitting file with segment time of %d seconds", segmentTime)
+	bitrateBps := int64(bitrateKbps) * 1000
+	measuredBps, err := probeBitrate(filePath)
+	if err != nil {
+		log.Printf("Could not measure bitrate, assuming %d kbps: %v", bitrateKbps, err)
+	} else {
+		log.Printf("Assumed bitrate %d kbps, measured %d kbps", bitrateKbps, measuredBps/1000)
+		bitrateBps = measuredBps
+	}
+
+	segmentTime := calculateSegmentTime(chunkSize, bitrateBps)
+
+	var silences []float64
+	var duration float64
+	if conf.SplitOnSilence {
+		duration, err = probeDuration(filePath)
+		if err == nil {
+			silences, err = detectSilences(filePath, job)
+		}
+		if errors.Is(err, errCancelled) {
+			return nil, err
+		}
+		if err != nil {
+			log.Println("Could not detect silence, using fixed cuts:", err)
+			silences = nil
+		} else {
+			log.Printf("Found %d silent stretches to cut on", len(silences))
+		}
+	}
+
+	reencode := conf.AccurateSplit
+	for attempt := 1; attempt <= maxSplitAttempts; attempt++ {
+		log.Printf("Splitting file with segment time of %d seconds", segmentTime)
+
+		partFiles, err := segmentFile(filePath, segmentTime, silences, duration, reencode, bitrateKbps, job)
+		if err != nil {
+			return nil, err
+		}
+
+		if !reencode {
+			if err := validateParts(partFiles); err != nil {
+				log.Println("Copied parts failed validation, re-encoding instead:", err)
+				removeFiles(partFiles)
+				reencode = true
+				continue
+			}
+		}
+
+		largest, err := largestFileSize(partFiles)
+		if err != nil {
+			removeFiles(partFiles)
+			return nil, err
+		}
+		if largest <= chunkSize {
+			log.Printf("File split successfully into %d parts", len(partFiles))
+			return partFiles, nil
+		}
+
+		removeFiles(partFiles)
+
+		// Shrink the segment in proportion to how far the biggest part
+		// overshot, always by at least a second so the loop makes progress.
+		shorter := int(float64(segmentTime) * float64(chunkSize) / float64(largest) * 0.95)
+		if shorter >= segmentTime {
+			shorter = segmentTime - 1
+		}
+		if shorter < 1 {
+			break
+		}
+		log.Printf("Largest part is %d bytes, over the %d byte limit; re-splitting", largest, chunkSize)
+		segmentTime = shorter
+	}
+
+	return nil, fmt.Errorf("could not split file into parts under %s", formatSize(chunkSize))
+}
 
-	outputPattern := fmt.Sprintf("%s.part%%03d.mp3", filePath)
+func segmentFile(filePath string, segmentTime int, silences []float64, duration float64, reencode bool, bitrateKbps int, job *activeJob) ([]string, error) {
+	ext := filepath.Ext(filePath)
+	// The segment muxer treats % in the name as a pattern, and titles may
+	// contain it.
+	outputPattern := strings.ReplaceAll(strings.TrimSuffix(filePath, ext), "%", "%%") + ".part%03d" + ext
 
-	cmd := exec.Command("ffmpeg", "-i", filePath, "-f", "segment", "-segment_time", fmt.Sprintf("%d", segmentTime), "-c", "copy", outputPattern)
+	var cuts []float64
+	if len(silences) > 0 {
+		cuts = silenceCutPoints(silences, duration, float64(segmentTime), float64(segmentTime)/0.95)
+	}
 
-	output, err := cmd.CombinedOutput()
+	args := []string{"-i", filePath, "-f", "segment"}
+	if len(cuts) > 0 {
+		args = append(args, "-segment_times", formatSegmentTimes(cuts))
+	} else {
+		args = append(args, "-segment_time", fmt.Sprintf("%d", segmentTime))
+	}
+	if reencode {
+		args = append(args, "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", bitrateKbps), outputPattern)
+	} else {
+		args = append(args, "-c", "copy", outputPattern)
+	}
+
+	cmd := exec.Command("ffmpeg", args...)
+
+	output, err := job.run(cmd)
+	if errors.Is(err, errCancelled) {
+		return nil, err
+	}
 	if err != nil {
 		log.Printf("Error splitting file with ffmpeg: %s\n%s", err, string(output))
 		return nil, err
 	}
 
-	i := 0
-	for {
-		partFilename := fmt.Sprintf("%s.part%03d.mp3", filePath, i)
-		if err := exec.Command("test", "-f", partFilename).Run(); err != nil {
-			break // No more parts exist
+	return findPartFiles(filePath)
+}
+
+func validateParts(partFiles []string) error {
+	for _, part := range partFiles {
+		cmd := exec.Command("ffprobe", "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", part)
+		var stderr strings.Builder
+		cmd.Stderr = &stderr
+		output, err := cmd.Output()
+		if err != nil {
+			return fmt.Errorf("%s: %v", filepath.Base(part), err)
+		}
+		if stderr.Len() > 0 {
+			return fmt.Errorf("%s: %s", filepath.Base(part), strings.TrimSpace(stderr.String()))
+		}
+		duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+		if err != nil || duration <= 0 {
+			return fmt.Errorf("%s: no playable audio", filepath.Base(part))
 		}
-		partFiles = append(partFiles, partFilename)
-		i++
 	}
+	return nil
+}
 
-	log.Printf("File split successfully into %d parts", len(partFiles))
-	return partFiles, nil
+func largestFileSize(paths []string) (int64, error) {
+	var largest int64
+	for _, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return 0, fmt.Errorf("could not check part size: %v", err)
+		}
+		if fileInfo.Size() > largest {
+			largest = fileInfo.Size()
+		}
+	}
+	return largest, nil
 }
 
-func calculateSegmentTime(chunkSize int64, bitrateKbps int) int {
-	chunkSizeKB := chunkSize / 1024
+func removeFiles(paths []string) {
+	for _, path := range paths {
+		os.Remove(path)
+	}
+}
 
-	bitrateKBps := int64(bitrateKbps / 8)
+func findPartFiles(filePath string) ([]string, error) {
+	ext := filepath.Ext(filePath)
+	prefix := strings.TrimSuffix(filePath, ext) + ".part"
+	matches, err := filepath.Glob(globEscape(prefix) + "*" + ext)
+	if err != nil {
+		return nil, fmt.Errorf("could not list split parts: %v", err)
+	}
 
-	segmentTime := chunkSizeKB / bitrateKBps
+	indexes := make(map[string]int, len(matches))
+	var partFiles []string
+	for _, match := range matches {
+		index, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(match, prefix), ext))
+		if err != nil {
+			continue
+		}
+		indexes[match] = index
+		partFiles = append(partFiles, match)
+	}
+
+	if len(partFiles) == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no parts")
+	}
+
+	sort.Slice(partFiles, func(i, j int) bool {
+		return indexes[partFiles[i]] < indexes[partFiles[j]]
+	})
+
+	return partFiles, nil
+}
+
+func globEscape(path string) string {
+	var sb strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			sb.WriteRune('\\')
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func calculateSegmentTime(chunkSize int64, bitrateBps int64) int {
+	// Keep a 5% margin since -c copy cuts on frame boundaries and VBR parts
+	// can run above the average bitrate.
+	segmentTime := float64(chunkSize) * 8 / float64(bitrateBps) * 0.95
 	return int(segmentTime)
 }
 
@@ -176,38 +970,317 @@ func isValidYouTubeURL(url string) bool {
 	return strings.Contains(url, "youtube.com/watch") || strings.Contains(url, "youtu.be/")
 }
 
-func downloadMp3(url string, chatID int64) (string, string, error) {
-	timestamp := time.Now().UnixNano()
-	filenameTemplate := fmt.Sprintf("download_%d_%d.%%(ext)s", chatID, timestamp)
+type downloadOptions struct {
+	Section  string
+	Zip      bool
+	Playlist bool
+	Merge    bool
+	FormatID string
+	LiveClip int // seconds to record from a live stream
+	Resumed  bool
+	Bitrate  int   // kbps a resumed download was started at
+	StatusID int   // status message a resumed download keeps editing
+	Force    bool  // skip the file_id cache
+	Tracks   []int // playlist positions to download, all when empty
+	Files    *fileBudget
+	Job      *activeJob
+	Slot     *queuedJob // download slot, given up while waiting on the user
+	Commit   bool       // commit Job once the upload starts, not before
+	Progress func(downloadProgress)
+
+	// Uploading is told what is being sent; part and parts are zero for a
+	// single file.
+	Uploading func(part int, parts int, size int64)
+}
+
+// beginUpload is called right before the first file goes out and reports
+// false if the job was cancelled first. With Commit set, it also commits the
+// job, which can't be cancelled from then on; a playlist's job stays
+// cancellable between its tracks.
+func (o downloadOptions) beginUpload() bool {
+	if o.Commit {
+		return o.Job.commit()
+	}
+	return !o.Job.isCancelled()
+}
 
-	cmd := exec.Command(
-		"yt-dlp",
-		"-x",
-		"--audio-format", "mp3",
-		"--audio-quality", fmt.Sprintf("%dK", bitrateKBps),
+func (o downloadOptions) uploading(part int, parts int, size int64) {
+	if o.Uploading != nil {
+		o.Uploading(part, parts, size)
+	}
+}
+
+// newJobDir creates a private working directory for one job under download-dir.
+// Everything the job produces (yt-dlp intermediates, fragments, split parts,
+// unsent files) lives there, so removeJobDir is all the cleanup it needs.
+func newJobDir(chatID int64) (string, error) {
+	dir, err := os.MkdirTemp(conf.DownloadDir, fmt.Sprintf("job_%d_", chatID))
+	if err != nil {
+		return "", fmt.Errorf("could not create job directory: %v", err)
+	}
+	// ffmpeg resolves concat list entries relative to the list file, so
+	// paths inside the job must not depend on the working directory.
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+	markJobActive(dir)
+	diskUsage.track(dir)
+	return dir, nil
+}
+
+// prepareDownloadDir creates dir if needed and checks the bot can write to
+// it, so a read-only location fails at startup rather than on every request.
+func prepareDownloadDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %v", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
+func removeJobDir(dir string) {
+	defer markJobDone(dir)
+	if keepTempFiles() {
+		log.Println("Keeping job directory:", dir)
+		diskUsage.finish(dir, false)
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("Could not remove %s: %v", dir, err)
+		diskUsage.finish(dir, false)
+		return
+	}
+	diskUsage.finish(dir, true)
+}
+
+// removeIntermediates deletes whatever the download left in dir besides the
+// final file and the job manifest: yt-dlp's source audio (.webm, .m4a,
+// .opus, ...), fragments and converter temp files. Which of these exist
+// depends on the site and format, so everything else in the directory goes.
+func removeIntermediates(dir string, finalPath string) {
+	if keepTempFiles() {
+		return
+	}
+	paths, err := filepath.Glob(filepath.Join(globEscape(dir), "*"))
+	if err != nil {
+		log.Println("Error listing intermediate files:", err)
+		return
+	}
+	for _, path := range paths {
+		if path == finalPath || filepath.Base(path) == jobManifestName || filepath.Base(path) == sentPartsName {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Could not remove intermediate %s: %v", path, err)
+			continue
+		}
+		if conf.DebugMode {
+			log.Println("Removed intermediate file:", path)
+		}
+	}
+}
+
+// keepTempFiles is only honoured in debug mode so a stray keep-temp-files
+// can't fill the disk in production.
+func keepTempFiles() bool {
+	return conf.DebugMode && conf.KeepTempFiles
+}
+
+// fileSize returns the size of path, or 0 if it can't be read.
+func fileSize(path string) int64 {
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return fileInfo.Size()
+}
+
+func removeTempFile(path string) {
+	if keepTempFiles() {
+		log.Println("Keeping temp file:", path)
+		return
+	}
+	os.Remove(path)
+}
+
+func downloadMp3(url string, dir string, kbps int, opts downloadOptions) (string, error) {
+	if err := opts.Files.take(1); err != nil {
+		return "", err
+	}
+
+	filenameTemplate := filepath.Join(dir, defaultOutputTemplate)
+	if conf.OutputTemplate != "" {
+		filenameTemplate = filepath.Join(dir, conf.OutputTemplate)
+	}
+
+	// Playlists are expanded by processPlaylist one entry at a time, so a
+	// list= parameter on a watch link must never pull in the whole list here.
+	args := []string{
+		"--no-playlist",
+		"--continue",
+		"--windows-filenames",
 		"-o", filenameTemplate,
-		url,
-	)
+		"--print", "after_move:filepath",
+	}
+	if opts.FormatID != "" {
+		args = append(args, "-f", opts.FormatID)
+	} else {
+		args = append(args, "-x", "--audio-format", "mp3", "--audio-quality", fmt.Sprintf("%dK", kbps))
+	}
+	if opts.Section != "" {
+		args = append(args, "--download-sections", opts.Section, "--force-keyframes-at-cuts")
+	}
+	if opts.LiveClip > 0 {
+		args = append(args, "--downloader", "ffmpeg", "--downloader-args", fmt.Sprintf("ffmpeg:-t %d", opts.LiveClip))
+	}
+	if opts.Progress != nil {
+		// --print implies --quiet, --progress brings the progress lines back.
+		args = append(args, "--progress", "--newline",
+			"--progress-template", downloadProgressTmpl,
+			"--progress-template", postprocessProgressTmpl)
+	}
+	args = append(args, "--", url)
 
-	output, err := cmd.CombinedOutput()
+	cmd := ytDlpCommand(args...)
 
-	log.Printf("yt-dlp output: %s", output)
+	var stdout, stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	pipe, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := opts.Job.start(cmd); err != nil {
+		if errors.Is(err, errCancelled) {
+			return "", err
+		}
+		return "", fmt.Errorf("could not start yt-dlp: %v", err)
+	}
+	defer opts.Job.done(cmd)
+	if opts.LiveClip > 0 {
+		watchdog := time.AfterFunc(time.Duration(opts.LiveClip)*time.Second+liveClipGrace, func() {
+			log.Println("Live recording overran, stopping yt-dlp")
+			cmd.Process.Kill()
+		})
+		defer watchdog.Stop()
+	}
+
+	scanner := bufio.NewScanner(pipe)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if progress, ok := parseProgressLine(line); ok {
+			if opts.Progress != nil {
+				opts.Progress(progress)
+			}
+			continue
+		}
+		stdout.WriteString(line + "\n")
+	}
+	err = cmd.Wait()
+
+	log.Printf("yt-dlp output: %s%s", stdout.String(), stderr.String())
+	if opts.Job.isCancelled() {
+		return "", errCancelled
+	}
 
 	if err != nil {
-		log.Println("Error executing yt-dlp:", err)
-		return "", "", err
+		output := stderr.String()
+		result := classifyExecError(err, stderr.Bytes())
+		log.Println("Error executing yt-dlp:", result)
+		return "", &downloadError{Kind: classifyYtDlpError(output), Exec: result, Output: output, Err: err}
+	}
+
+	mp3Filename := lastLine(stdout.String())
+	if mp3Filename == "" {
+		return "", fmt.Errorf("yt-dlp did not report the output file")
+	}
+	if _, err := os.Stat(mp3Filename); err != nil {
+		return "", fmt.Errorf("yt-dlp output file is missing: %v", err)
+	}
+
+	// yt-dlp only strips what the local filesystem rejects; tidy the name
+	// so it is safe in ffmpeg arguments and wherever the user saves it.
+	ext := filepath.Ext(mp3Filename)
+	safeName := filepath.Join(filepath.Dir(mp3Filename), sanitizeFilename(strings.TrimSuffix(filepath.Base(mp3Filename), ext))+ext)
+	if safeName != mp3Filename {
+		if err := os.Rename(mp3Filename, safeName); err != nil {
+			log.Println("Could not rename output file, keeping yt-dlp's name:", err)
+			return mp3Filename, nil
+		}
 	}
 
-	mp3Filename := fmt.Sprintf("download_%d_%d.mp3", chatID, timestamp)
-	m4aFilename := fmt.Sprintf("download_%d_%d.m4a", chatID, timestamp)
+	return safeName, nil
+}
 
-	return mp3Filename, m4aFilename, nil
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
 }
 
-func loadConfig() (*Config, error) {
+func validateOutputTemplate(template string) error {
+	if !strings.Contains(template, "%(ext)s") {
+		return fmt.Errorf("must contain %%(ext)s")
+	}
+	if filepath.IsAbs(template) || strings.HasPrefix(filepath.Clean(template), "..") {
+		return fmt.Errorf("must be a relative path inside the job directory")
+	}
+	return nil
+}
+
+// ytDlpCommand builds a yt-dlp command with the configured proxy and cookies.
+// Callers pass the URL after "--", so a link starting with "-" can't be read
+// as an option.
+func ytDlpCommand(args ...string) *exec.Cmd {
+	if conf.Proxy != "" {
+		args = append([]string{"--proxy", conf.Proxy}, args...)
+	}
+	if conf.CookiesFile != "" {
+		args = append([]string{"--cookies", conf.CookiesFile}, args...)
+	}
+	return exec.Command("yt-dlp", args...)
+}
+
+func validateProxy(proxy string) error {
+	parsed, err := neturl.Parse(proxy)
+	if err != nil {
+		return err
+	}
+	switch parsed.Scheme {
+	case "http", "https", "socks4", "socks4a", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// readConfig returns the file CONFIG_PATH points at, or else the config.json
+// embedded at build time.
+func readConfig() ([]byte, error) {
+	if path := os.Getenv("CONFIG_PATH"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read config from CONFIG_PATH %s: %v", path, err)
+		}
+		return data, nil
+	}
+
 	data, err := configFile.ReadFile("config.json")
 	if err != nil {
-		return nil, fmt.Errorf("could not read config: %v", err)
+		return nil, fmt.Errorf("could not read embedded config.json (set CONFIG_PATH to use a file on disk): %v", err)
+	}
+	return data, nil
+}
+
+func loadConfig() (*Config, error) {
+	data, err := readConfig()
+	if err != nil {
+		return nil, err
 	}
 
 	var config Config
@@ -216,5 +1289,87 @@ func loadConfig() (*Config, error) {
 		return nil, fmt.Errorf("could not parse config: %v", err)
 	}
 
+	if config.PrefsFile == "" {
+		config.PrefsFile = "prefs.json"
+	}
+	if config.CacheFile == "" {
+		config.CacheFile = "upload-cache.db"
+	}
+	if config.DownloadDir == "" {
+		config.DownloadDir = "."
+	}
+	if config.ReapIntervalMinutes <= 0 {
+		config.ReapIntervalMinutes = defaultReapIntervalMinutes
+	}
+	if config.ReapMaxAgeMinutes <= 0 {
+		config.ReapMaxAgeMinutes = defaultReapMaxAgeMinutes
+	}
+	if config.LogMaxSizeMB <= 0 {
+		config.LogMaxSizeMB = defaultLogMaxSizeMB
+	}
+	if config.LogMaxBackups == nil {
+		backups := defaultLogMaxBackups
+		config.LogMaxBackups = &backups
+	} else if *config.LogMaxBackups < 0 {
+		*config.LogMaxBackups = 0
+	}
+	if config.MaxConcurrentSplits <= 0 {
+		config.MaxConcurrentSplits = max(1, runtime.NumCPU()-1)
+	}
+	if config.MaxDocumentSizeMB <= 0 {
+		config.MaxDocumentSizeMB = publicMaxDocumentSizeMB
+		if config.APIEndpoint != "" {
+			config.MaxDocumentSizeMB = localMaxDocumentSizeMB
+		}
+	}
+	if config.TrimSilenceThresholdDB == nil {
+		threshold := defaultTrimSilenceThresholdDB
+		config.TrimSilenceThresholdDB = &threshold
+	}
+	if config.TrimSilenceKeepSeconds == nil {
+		keep := defaultTrimSilenceKeepSeconds
+		config.TrimSilenceKeepSeconds = &keep
+	}
+	if config.FadeSeconds == nil {
+		fade := defaultFadeSeconds
+		config.FadeSeconds = &fade
+	}
+	if config.ReencodeMaxFactor == 0 {
+		config.ReencodeMaxFactor = defaultReencodeMaxFactor
+	}
+	if config.OutputTemplate != "" {
+		if err := validateOutputTemplate(config.OutputTemplate); err != nil {
+			return nil, fmt.Errorf("invalid output-template %q: %v", config.OutputTemplate, err)
+		}
+	}
+	if config.APIEndpoint != "" {
+		if err := validateAPIEndpoint(config.APIEndpoint); err != nil {
+			return nil, fmt.Errorf("invalid api-endpoint %q: %v", config.APIEndpoint, err)
+		}
+	}
+	if config.Proxy != "" {
+		if err := validateProxy(config.Proxy); err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", config.Proxy, err)
+		}
+	}
+	if config.CookiesFile != "" {
+		if _, err := os.Stat(config.CookiesFile); err != nil {
+			return nil, fmt.Errorf("invalid cookies-file: %v", err)
+		}
+	}
+	if !isValidSampleRate(config.SampleRate) {
+		return nil, fmt.Errorf("unsupported sample-rate %d", config.SampleRate)
+	}
+	if !isValidChannels(config.Channels) {
+		return nil, fmt.Errorf("channels must be 1 or 2, got %d", config.Channels)
+	}
+	if err := validateConfig(&config); err != nil {
+		return nil, err
+	}
+	captionTemplate, err = compileCaptionTemplate(config.CaptionTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("invalid caption-template: %v", err)
+	}
+
 	return &config, nil
 }
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// touch creates empty files named names in dir.
+func touch(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestFindPartFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		stem  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "numeric order",
+			stem:  "song",
+			files: []string{"song.part10.mp3", "song.part2.mp3", "song.part0.mp3", "song.part9.mp3", "song.part1.mp3", "song.part11.mp3"},
+			want:  []string{"song.part0.mp3", "song.part1.mp3", "song.part2.mp3", "song.part9.mp3", "song.part10.mp3", "song.part11.mp3"},
+		},
+		{
+			name:  "gaps in the index",
+			stem:  "song",
+			files: []string{"song.part5.mp3", "song.part0.mp3", "song.part2.mp3"},
+			want:  []string{"song.part0.mp3", "song.part2.mp3", "song.part5.mp3"},
+		},
+		{
+			name:  "other files ignored",
+			stem:  "song",
+			files: []string{"song.mp3", "song.part0.mp3", "song.part1.m4a", "song.partial.mp3", "song.part1.mp3.tmp", "other.part0.mp3"},
+			want:  []string{"song.part0.mp3"},
+		},
+		{
+			// Unescaped, "[Live]" would be a character class and "*" would
+			// match the decoy as well.
+			name:  "glob metacharacters in the title",
+			stem:  "Song [Live] *best*",
+			files: []string{"Song [Live] *best*.part1.mp3", "Song [Live] *best*.part0.mp3", "Song L *best*.part0.mp3", "Song [Live] xbestx.part0.mp3"},
+			want:  []string{"Song [Live] *best*.part0.mp3", "Song [Live] *best*.part1.mp3"},
+		},
+		{
+			name:  "question mark and backslash",
+			stem:  `What? a\b`,
+			files: []string{`What? a\b.part0.mp3`, `Whatx a\b.part1.mp3`, `What? ab.part2.mp3`},
+			want:  []string{`What? a\b.part0.mp3`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			touch(t, dir, tt.files...)
+
+			got, err := findPartFiles(filepath.Join(dir, tt.stem+".mp3"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := make([]string, len(tt.want))
+			for i, name := range tt.want {
+				want[i] = filepath.Join(dir, name)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("findPartFiles = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestFindPartFilesWithoutParts(t *testing.T) {
+	dir := t.TempDir()
+	touch(t, dir, "song.mp3", "song.partial.mp3")
+
+	parts, err := findPartFiles(filepath.Join(dir, "song.mp3"))
+	if err == nil {
+		t.Fatalf("findPartFiles = %q, want an error", parts)
+	}
+}
+
+// requireFFmpeg skips tests that need ffmpeg and ffprobe where they aren't
+// installed.
+func requireFFmpeg(t *testing.T) {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not installed", tool)
+		}
+	}
+}
+
+func TestReencodeReplacesBrokenParts(t *testing.T) {
+	requireFFmpeg(t)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "tone.mp3")
+	out, err := exec.Command("ffmpeg", "-v", "error", "-f", "lavfi", "-i", "sine=frequency=440:duration=30", "-c:a", "libmp3lame", "-b:a", "64k", source).CombinedOutput()
+	if err != nil {
+		t.Fatalf("could not make test audio: %v\n%s", err, out)
+	}
+
+	parts, err := segmentFile(source, 10, nil, 0, false, 64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) < 2 {
+		t.Fatalf("got %d parts, want several", len(parts))
+	}
+	if err := validateParts(parts); err != nil {
+		t.Fatalf("copied parts of a clean file failed validation: %v", err)
+	}
+
+	// What a bad stream copy leaves behind: a part with no playable audio.
+	if err := os.WriteFile(parts[1], []byte("not an mp3 at all"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateParts(parts); err == nil {
+		t.Fatal("validateParts accepted a broken part")
+	}
+
+	removeFiles(parts)
+	parts, err = segmentFile(source, 10, nil, 0, true, 64, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := validateParts(parts); err != nil {
+		t.Fatalf("re-encoded parts failed validation: %v", err)
+	}
+
+	var total float64
+	for _, part := range parts {
+		duration, err := probeDuration(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += duration
+	}
+	if math.Abs(total-30) > 1 {
+		t.Errorf("re-encoded parts add up to %.2fs, want about 30s", total)
+	}
+}
+
+func TestSendFileStreamsFromDisk(t *testing.T) {
+	if testing.Short() {
+		t.Skip("uploads a 300 MB file")
+	}
+	savedConf := conf
+	defer func() { conf = savedConf }()
+	conf = &Config{}
+
+	const size = 300 << 20
+	path := filepath.Join(t.TempDir(), "large.mp3")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(size); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// A stand-in for the Bot API that reads and discards the upload.
+	var uploaded atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			io.WriteString(w, `{"ok":true,"result":{"id":1,"is_bot":true,"first_name":"bot","username":"bot"}}`)
+		case strings.HasSuffix(r.URL.Path, "/sendAudio"):
+			n, _ := io.Copy(io.Discard, r.Body)
+			uploaded.Add(n)
+			io.WriteString(w, `{"ok":true,"result":{"message_id":2,"chat":{"id":1,"type":"private"},"audio":{"file_id":"audio"}}}`)
+		default:
+			io.WriteString(w, `{"ok":true,"result":true}`)
+		}
+	}))
+	defer server.Close()
+	bot, err := tgbotapi.NewBotAPIWithClient("token", server.URL+"/bot%s/%s", server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if err := sendFile(bot, path, 1, trackMeta{Title: "large", Duration: 1}); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+
+	if uploaded.Load() < size {
+		t.Fatalf("server received %d bytes, want at least %d", uploaded.Load(), size)
+	}
+	// Both ends of the upload run in this process, so this counts the copy
+	// buffers on either side; buffering the file would take its whole size.
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated > size/10 {
+		t.Fatalf("sending a %d MB file allocated %d MB", size>>20, allocated>>20)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func handleCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery) {
+	rememberLanguage(query.From)
+	action, arg, _ := strings.Cut(query.Data, ":")
+
+	switch action {
+	case "confirm":
+		handleConfirmCallback(bot, query, arg, true)
+	case "reject":
+		handleConfirmCallback(bot, query, arg, false)
+	case "live":
+		handleLiveCallback(bot, query, arg)
+	case "split":
+		handleOversizeCallback(bot, query, arg, false)
+	case "shrink":
+		handleOversizeCallback(bot, query, arg, true)
+	case "cancel":
+		handleCancelCallback(bot, query, arg)
+	case "history":
+		handleHistoryCallback(bot, query, arg)
+	case "settings":
+		handleSettingsCallback(bot, query, arg)
+	default:
+		answerCallback(bot, query, "")
+	}
+}
+
+func answerCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, text string) {
+	callback := tgbotapi.NewCallback(query.ID, text)
+	throttle(callback)
+	if _, err := bot.Request(callback); err != nil {
+		log.Println("Error answering callback:", err)
+	}
+}
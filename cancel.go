@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var errCancelled = errors.New("the download was cancelled")
+
+// activeJob is a running download that can still be cancelled from its
+// status message.
+type activeJob struct {
+	id     string
+	chatID int64
+	userID int64
+	lang   string
+
+	mu        sync.Mutex
+	cmds      map[*exec.Cmd]bool
+	cancelled bool
+	committed bool
+	// stopped is closed on cancelling, for waits that no process backs.
+	stopped chan struct{}
+	// shrink answers the oversize prompt for the rest of the job once
+	// the user has been asked.
+	shrink *bool
+}
+
+var cancellableJobs = struct {
+	mu   sync.Mutex
+	next int
+	byID map[string]*activeJob
+}{byID: make(map[string]*activeJob)}
+
+func startActiveJob(message *tgbotapi.Message) *activeJob {
+	job := &activeJob{chatID: message.Chat.ID, lang: langOf(message), cmds: make(map[*exec.Cmd]bool), stopped: make(chan struct{})}
+	if message.From != nil {
+		job.userID = message.From.ID
+	}
+
+	cancellableJobs.mu.Lock()
+	cancellableJobs.next++
+	job.id = strconv.Itoa(cancellableJobs.next)
+	cancellableJobs.byID[job.id] = job
+	cancellableJobs.mu.Unlock()
+	return job
+}
+
+func findActiveJob(id string) *activeJob {
+	cancellableJobs.mu.Lock()
+	defer cancellableJobs.mu.Unlock()
+	return cancellableJobs.byID[id]
+}
+
+func activeJobsIn(chatID int64) []*activeJob {
+	cancellableJobs.mu.Lock()
+	defer cancellableJobs.mu.Unlock()
+
+	var jobs []*activeJob
+	for _, job := range cancellableJobs.byID {
+		if job.chatID == chatID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+// finish forgets the job; cancelling it afterwards does nothing.
+func (j *activeJob) finish() {
+	if j == nil {
+		return
+	}
+	cancellableJobs.mu.Lock()
+	delete(cancellableJobs.byID, j.id)
+	cancellableJobs.mu.Unlock()
+}
+
+// start runs cmd in its own process group and tracks it until done is
+// called, refusing to start anything once the job is cancelled.
+func (j *activeJob) start(cmd *exec.Cmd) error {
+	if j == nil {
+		return cmd.Start()
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.cancelled {
+		return errCancelled
+	}
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	j.cmds[cmd] = true
+	return nil
+}
+
+// run is cmd.CombinedOutput for a command that is killed along with the job.
+func (j *activeJob) run(cmd *exec.Cmd) ([]byte, error) {
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := j.start(cmd); err != nil {
+		return nil, err
+	}
+	defer j.done(cmd)
+	err := cmd.Wait()
+	if j.isCancelled() {
+		return output.Bytes(), errCancelled
+	}
+	return output.Bytes(), err
+}
+
+func (j *activeJob) done(cmd *exec.Cmd) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	delete(j.cmds, cmd)
+	j.mu.Unlock()
+}
+
+func (j *activeJob) isCancelled() bool {
+	if j == nil {
+		return false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.cancelled
+}
+
+// stop is closed once the job is cancelled; it never is for a nil job.
+func (j *activeJob) stop() <-chan struct{} {
+	if j == nil {
+		return nil
+	}
+	return j.stopped
+}
+
+// commit marks the point past which the job is no longer cancelled, such as
+// the upload starting. It reports false if the job was cancelled first.
+func (j *activeJob) commit() bool {
+	if j == nil {
+		return true
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.committed = !j.cancelled
+	return j.committed
+}
+
+// cancel kills the job's running processes; it reports false if the job had
+// already committed to finishing.
+func (j *activeJob) cancel() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.committed {
+		return false
+	}
+	if !j.cancelled {
+		close(j.stopped)
+	}
+	j.cancelled = true
+	for cmd := range j.cmds {
+		if err := killProcessGroup(cmd); err != nil {
+			log.Println("Error killing process group:", err)
+		}
+	}
+	return true
+}
+
+func cancelKeyboard(job *activeJob) *tgbotapi.InlineKeyboardMarkup {
+	keyboard := tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr(job.lang, "cancel.button", job.id), "cancel:"+job.id),
+		),
+	)
+	return &keyboard
+}
+
+// replyWithCancel answers message with text and a Cancel button for job, for
+// jobs that have no status message of their own. The returned func takes the
+// button off again once the job is over.
+func replyWithCancel(bot *tgbotapi.BotAPI, message *tgbotapi.Message, text string, job *activeJob) func() {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.ReplyMarkup = cancelKeyboard(job)
+	sent, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return func() {}
+	}
+	return func() {
+		edit := tgbotapi.NewEditMessageReplyMarkup(message.Chat.ID, sent.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}})
+		if _, err := sendMessage(bot, edit); err != nil {
+			log.Println("Error removing cancel button:", err)
+		}
+	}
+}
+
+func mayCancel(userID int64, jobUserID int64) bool {
+	return userID == jobUserID || isAdmin(userID)
+}
+
+// handleCancel cancels the sender's running and queued downloads in the chat,
+// or just the one whose number is on its Cancel button.
+func handleCancel(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	if message.From == nil {
+		return
+	}
+	id := strings.TrimPrefix(strings.TrimSpace(args), "#")
+
+	if id != "" {
+		job := findActiveJob(id)
+		if job == nil || job.chatID != message.Chat.ID {
+			replyText(bot, message, tr(langOf(message), "cancel.no_such", id))
+			return
+		}
+		if !mayCancel(message.From.ID, job.userID) {
+			replyText(bot, message, tr(langOf(message), "cancel.not_yours"))
+			return
+		}
+		if !job.cancel() {
+			replyText(bot, message, tr(langOf(message), "cancel.too_late"))
+			return
+		}
+		replyText(bot, message, tr(langOf(message), "cancel.cancelled_one", id))
+		return
+	}
+
+	cancelled := 0
+	for _, job := range activeJobsIn(message.Chat.ID) {
+		if mayCancel(message.From.ID, job.userID) && job.cancel() {
+			cancelled++
+		}
+	}
+	for _, job := range downloads.pendingInChat(message.Chat.ID) {
+		if mayCancel(message.From.ID, job.userID) && downloads.remove(job) {
+			cancelled++
+		}
+	}
+
+	if cancelled == 0 {
+		replyText(bot, message, tr(langOf(message), "cancel.nothing"))
+		return
+	}
+	log.Printf("User %d cancelled %d download(s) in chat %d", message.From.ID, cancelled, message.Chat.ID)
+	replyText(bot, message, trn(langOf(message), "cancel.cancelled", cancelled))
+}
+
+func handleCancelCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, id string) {
+	job := findActiveJob(id)
+	if job == nil {
+		answerCallback(bot, query, tr(callbackLanguage(query), "cancel.finished"))
+		return
+	}
+	if query.From == nil || (query.From.ID != job.userID && !isAdmin(query.From.ID)) {
+		answerCallback(bot, query, tr(callbackLanguage(query), "cancel.not_yours"))
+		return
+	}
+
+	if !job.cancel() {
+		answerCallback(bot, query, tr(callbackLanguage(query), "cancel.too_late"))
+		return
+	}
+	answerCallback(bot, query, tr(callbackLanguage(query), "cancel.cancelling"))
+}
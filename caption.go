@@ -0,0 +1,85 @@
+package main
+
+import (
+	"strings"
+	"text/template"
+	"unicode/utf16"
+)
+
+// Telegram counts caption length in UTF-16 code units.
+const maxCaptionLength = 1024
+
+// captionTemplate is caption-template compiled at startup; nil when unset.
+var captionTemplate *template.Template
+
+// captionData is what caption-template is rendered with.
+type captionData struct {
+	Title     string
+	Uploader  string
+	SourceURL string
+	Duration  string // like 12:34, empty if unknown
+	Size      string // like 11.2 MB, empty if unknown
+	Bitrate   int    // kbps
+	Part      int    // 1-based, zero unless the file was split
+	Parts     int
+	Note      string
+}
+
+// compileCaptionTemplate parses text and renders it once with sample values,
+// so a mistake such as an unknown field fails at startup rather than on
+// every send.
+func compileCaptionTemplate(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	tmpl, err := template.New("caption-template").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := captionData{Title: "Title", Uploader: "Uploader", SourceURL: "https://youtu.be/id", Duration: "3:45", Size: "3.4 MB", Bitrate: 128, Part: 1, Parts: 2}
+	if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+func (m trackMeta) templateCaption(duration int, size int64) (string, error) {
+	data := captionData{Title: m.Title, Uploader: m.Uploader, SourceURL: m.URL, Bitrate: m.Bitrate, Part: m.Part, Parts: m.Parts, Note: m.Note}
+	if duration > 0 {
+		data.Duration = formatDuration(duration)
+	}
+	if size > 0 {
+		data.Size = formatSize(size)
+	}
+
+	var sb strings.Builder
+	if err := captionTemplate.Execute(&sb, data); err != nil {
+		return "", err
+	}
+	return truncateUTF16(strings.TrimSpace(sb.String()), maxCaptionLength), nil
+}
+
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// truncateUTF16 cuts s to at most limit UTF-16 code units, ending with an
+// ellipsis when anything was cut.
+func truncateUTF16(s string, limit int) string {
+	if utf16Len(s) <= limit {
+		return s
+	}
+	units := 0
+	for i, r := range s {
+		size := 1
+		if r >= 0x10000 {
+			size = 2
+		}
+		// Leave room for the ellipsis.
+		if units+size > limit-1 {
+			return s[:i] + "…"
+		}
+		units += size
+	}
+	return s
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestCaptionsFitTelegramLimit(t *testing.T) {
+	savedConf, savedTemplate := conf, captionTemplate
+	defer func() { conf, captionTemplate = savedConf, savedTemplate }()
+	conf = &Config{}
+
+	meta := trackMeta{
+		Title:    strings.Repeat("Title ", 100),
+		Uploader: strings.Repeat("Uploader ", 100),
+		URL:      "https://www.youtube.com/watch?v=abc&x=" + strings.Repeat("y", 2000),
+		Bitrate:  192,
+		Lang:     "en",
+		Note:     strings.Repeat("note & ", 300),
+	}
+	tmpl := template.Must(template.New("caption-template").Parse("{{.Title}}\n{{.SourceURL}}\n{{.Note}}"))
+
+	for _, style := range []string{"plain", "rich", "template"} {
+		conf.CaptionStyle, captionTemplate = style, nil
+		if style == "template" {
+			conf.CaptionStyle, captionTemplate = "rich", tmpl
+		}
+		caption, mode := meta.captionFor(754, 12<<20)
+		shown := caption
+		if mode != "" {
+			shown = htmlText(caption)
+		}
+		if n := utf16Len(shown); n > maxCaptionLength {
+			t.Errorf("%s caption is %d characters long, want at most %d", style, n, maxCaptionLength)
+		}
+		if !strings.HasSuffix(shown, "…") {
+			t.Errorf("%s caption doesn't end in an ellipsis: %q", style, shown[len(shown)-20:])
+		}
+	}
+}
+
+// htmlText strips tags and unescapes entities the way Telegram does before
+// counting a caption's length.
+func htmlText(s string) string {
+	var sb strings.Builder
+	inTag := false
+	for _, r := range s {
+		switch {
+		case r == '<':
+			inTag = true
+		case r == '>':
+			inTag = false
+		case !inTag:
+			sb.WriteRune(r)
+		}
+	}
+	return strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&#34;", `"`, "&#39;", "'").Replace(sb.String())
+}
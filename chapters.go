@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxChapterListLength = 3500
+
+func handleChapters(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 || !isValidYouTubeURL(fields[0]) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "chapters.usage"))
+		return
+	}
+	url := fields[0]
+
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), err))
+		return
+	}
+	if len(info.Chapters) == 0 {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "chapters.none"))
+		return
+	}
+
+	if len(fields) == 1 {
+		var sb strings.Builder
+		sb.WriteString(trn(langOf(message), "chapters.list", len(info.Chapters), info.Title) + "\n")
+		for i, chapter := range info.Chapters {
+			line := fmt.Sprintf("%d. %s (%s–%s)\n", i+1, chapter.Title, formatDuration(int(chapter.StartTime)), formatDuration(int(chapter.EndTime)))
+			if sb.Len()+len(line) > maxChapterListLength {
+				sb.WriteString("…\n")
+				break
+			}
+			sb.WriteString(line)
+		}
+		sb.WriteString("\n" + tr(langOf(message), "chapters.hint", url))
+		sendText(bot, message.Chat.ID, sb.String())
+		return
+	}
+
+	number, err := strconv.Atoi(fields[1])
+	if err != nil || number < 1 || number > len(info.Chapters) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "chapters.bad_number", len(info.Chapters)))
+		return
+	}
+	chapter := info.Chapters[number-1]
+
+	chapterInfo := *info
+	chapterInfo.Title = fmt.Sprintf("%s — %s", info.Title, chapter.Title)
+	chapterInfo.Duration = chapter.EndTime - chapter.StartTime
+	if reason := checkDurationLimit(langOf(message), &chapterInfo); reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+
+	opts := downloadOptions{Section: fmt.Sprintf("*%.3f-%.3f", chapter.StartTime, chapter.EndTime)}
+	processDownload(bot, message, url, &chapterInfo, opts)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram shows a chat action for about five seconds.
+const chatActionEvery = 5 * time.Second
+
+// startChatAction shows action (e.g. "upload_voice") in the chat until the
+// returned function is called, so a slow upload doesn't look like the bot
+// stalled.
+func startChatAction(bot *tgbotapi.BotAPI, chatID int64, action string) context.CancelFunc {
+	ctx, cancel := context.WithCancel(context.Background())
+	go keepChatAction(ctx, bot, chatID, action)
+	return cancel
+}
+
+func keepChatAction(ctx context.Context, bot *tgbotapi.BotAPI, chatID int64, action string) {
+	ticker := time.NewTicker(chatActionEvery)
+	defer ticker.Stop()
+	for {
+		chatAction := tgbotapi.NewChatAction(chatID, action)
+		throttle(chatAction)
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := bot.Request(chatAction); err != nil {
+			log.Println("Error sending chat action:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
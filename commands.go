@@ -0,0 +1,123 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type commandHandler func(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string)
+
+var commands = map[string]commandHandler{
+	"start":        noArgs(handleStart),
+	"help":         noArgs(handleHelp),
+	"audio":        handleAudioSettings,
+	"audiobook":    handleAudiobook,
+	"cache":        adminOnly(handleCacheCommand),
+	"chapters":     handleChapters,
+	"autoplaylist": handleAutoPlaylistSetting,
+	"trimsilence":  handleTrimSilenceSetting,
+	"zip":          handleZipSetting,
+	"fade":         handleFadeSetting,
+	"formats":      handleFormats,
+	"formatid":     handleFormatID,
+	"retry":        noArgs(handleRetry),
+	"last":         noArgs(handleLast),
+	"history":      handleHistory,
+	"aac":          handleAACSetting,
+	"speed":        handleSpeedSetting,
+	"playlist":     handlePlaylistPreview,
+	"mystats":      noArgs(handleMyStats),
+	"queue":        handleQueue,
+	"sites":        noArgs(handleSites),
+	"cancel":       handleCancel,
+	"quality":      handleQuality,
+	"settings":     noArgs(handleSettings),
+	"setlang":      handleSetLang,
+	"lang":         handleLang,
+	"subs":         handleSubs,
+	"thumb":        handleThumb,
+	"transcribe":   handleTranscribe,
+	"voice":        handleVoice,
+}
+
+func handleCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	handler, ok := commands[strings.ToLower(message.Command())]
+	if !ok {
+		// In groups it is likely meant for another bot.
+		if !isGroupChat(message.Chat) {
+			replyText(bot, message, tr(langOf(message), "command.unknown"))
+		}
+		return
+	}
+	handler(bot, message, message.CommandArguments())
+}
+
+func noArgs(fn func(bot *tgbotapi.BotAPI, message *tgbotapi.Message)) commandHandler {
+	return func(bot *tgbotapi.BotAPI, message *tgbotapi.Message, _ string) {
+		fn(bot, message)
+	}
+}
+
+func adminOnly(handler commandHandler) commandHandler {
+	return func(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+		if message.From == nil || !isAdmin(message.From.ID) {
+			replyText(bot, message, tr(langOf(message), "command.admin_only"))
+			return
+		}
+		handler(bot, message, args)
+	}
+}
+
+// registerCommands publishes the commands everyone can use, so clients list
+// them in the "/" menu. English is the default list; every other catalog gets
+// its own, which clients in that language show instead.
+func registerCommands(bot *tgbotapi.BotAPI) {
+	for _, lang := range sortedKeys(catalogs) {
+		var botCommands []tgbotapi.BotCommand
+		for _, c := range commandHelps {
+			if c.adminOnly || (c.enabled != nil && !c.enabled()) {
+				continue
+			}
+			botCommands = append(botCommands, tgbotapi.BotCommand{Command: c.name, Description: c.description(lang)})
+		}
+
+		setCommands := tgbotapi.NewSetMyCommands(botCommands...)
+		if lang != defaultLanguage {
+			setCommands.LanguageCode = lang
+		}
+		throttle(setCommands)
+		if _, err := bot.Request(setCommands); err != nil {
+			log.Printf("Error registering commands for %s: %v", lang, err)
+			continue
+		}
+		log.Printf("Registered %d commands for %s", len(botCommands), lang)
+	}
+}
+
+// replyText is sendText threaded under the user's request when
+// reply-to-requests is on.
+func replyText(bot *tgbotapi.BotAPI, message *tgbotapi.Message, text string) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	_, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
+
+func replyTarget(message *tgbotapi.Message) int {
+	if !conf.ReplyToRequests {
+		return 0
+	}
+	return message.MessageID
+}
+
+func sendText(bot *tgbotapi.BotAPI, chatID int64, text string) {
+	msg := tgbotapi.NewMessage(chatID, text)
+	_, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+	}
+}
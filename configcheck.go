@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const templateBotToken = "your token :)"
+
+// validateConfig checks the values loadConfig can't fill in, naming the
+// offending key so a bad config.json is easy to fix.
+func validateConfig(config *Config) error {
+	switch config.BotToken {
+	case "":
+		return fmt.Errorf("bot-token is required")
+	case templateBotToken:
+		return fmt.Errorf("bot-token is still the placeholder from config-template.json")
+	}
+
+	if config.HealthPort < 0 || config.HealthPort > 65535 {
+		return fmt.Errorf("health-port must be between 0 and 65535, got %d", config.HealthPort)
+	}
+	if config.WhisperPath != "" && config.WhisperModel == "" {
+		return fmt.Errorf("whisper-model is required when whisper-path is set")
+	}
+	if config.ReencodeMaxFactor < 1 {
+		return fmt.Errorf("reencode-max-factor must be at least 1, got %g", config.ReencodeMaxFactor)
+	}
+	switch config.AckStyle {
+	case "", ackMessage, ackReaction:
+	default:
+		return fmt.Errorf("ack-style must be %q or %q, got %q", ackMessage, ackReaction, config.AckStyle)
+	}
+	switch config.CaptionStyle {
+	case "", "rich", "plain", "off":
+	default:
+		return fmt.Errorf("caption-style must be \"rich\", \"plain\" or \"off\", got %q", config.CaptionStyle)
+	}
+	if !isValidAACQuality(config.AACQuality) {
+		return fmt.Errorf("aac-quality must be 0 or between %g and %g, got %g", minAACQuality, maxAACQuality, config.AACQuality)
+	}
+	if !isValidSpeed(config.Speed) {
+		return fmt.Errorf("speed must be 0 or between %g and %g, got %g", minSpeed, maxSpeed, config.Speed)
+	}
+	if *config.TrimSilenceThresholdDB > 0 {
+		return fmt.Errorf("trim-silence-threshold-db must be 0 or negative, got %d", *config.TrimSilenceThresholdDB)
+	}
+
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"transcribe-timeout-minutes", float64(config.TranscribeTimeoutMinutes)},
+		{"max-duration-minutes", float64(config.MaxDurationMinutes)},
+		{"max-concurrent-downloads", float64(config.MaxConcurrentDownloads)},
+		{"concurrent-uploads", float64(config.ConcurrentUploads)},
+		{"trim-silence-keep-seconds", *config.TrimSilenceKeepSeconds},
+		{"fade-seconds", *config.FadeSeconds},
+		{"daily-quota", float64(config.DailyQuota)},
+		{"max-playlist-items", float64(config.MaxPlaylistItems)},
+		{"max-output-files", float64(config.MaxOutputFiles)},
+		{"max-disk-usage-mb", float64(config.MaxDiskUsageMB)},
+		{"converted-cache-files", float64(config.ConvertedCacheFiles)},
+	} {
+		if field.value < 0 {
+			return fmt.Errorf("%s must not be negative, got %g", field.name, field.value)
+		}
+	}
+
+	for _, file := range []struct {
+		name string
+		path string
+	}{
+		{"prefs-file", config.PrefsFile},
+		{"cache-file", config.CacheFile},
+		{"log-file", config.LogFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if err := checkWritableDir(filepath.Dir(file.path)); err != nil {
+			return fmt.Errorf("%s %q: %v", file.name, file.path, err)
+		}
+	}
+
+	return nil
+}
+
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("directory does not exist: %v", err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %v", err)
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
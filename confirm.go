@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const confirmationTTL = 10 * time.Minute
+
+type pendingRequest struct {
+	message  *tgbotapi.Message
+	url      string
+	info     *videoInfo
+	opts     downloadOptions
+	promptID int
+	timer    *time.Timer
+}
+
+var (
+	pendingMu     sync.Mutex
+	pendingNextID int
+	pending       = make(map[string]*pendingRequest)
+)
+
+func nextPendingID() string {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pendingNextID++
+	return strconv.Itoa(pendingNextID)
+}
+
+// storePending keeps req under id until it's answered or expires, at which
+// point the prompt is edited to say so.
+func storePending(bot *tgbotapi.BotAPI, id string, req *pendingRequest, text string) {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	pending[id] = req
+	req.timer = time.AfterFunc(confirmationTTL, func() {
+		if takePending(id) == nil {
+			return
+		}
+		edit := tgbotapi.NewEditMessageText(req.message.Chat.ID, req.promptID, text+"\n\n"+tr(langOf(req.message), "confirm.expired"))
+		if _, err := sendMessage(bot, edit); err != nil {
+			log.Println("Error updating prompt:", err)
+		}
+	})
+}
+
+func askConfirmation(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string, info *videoInfo, opts downloadOptions) {
+	id := nextPendingID()
+
+	kbps, _ := selectBitrate(message.Chat.ID, langOf(message), info)
+	estimatedSize := estimateSize(info.Duration, kbps)
+	text := info.Title + "\n" + tr(langOf(message), "confirm.summary", info.Uploader, formatDuration(int(info.Duration)), formatSize(estimatedSize), kbps)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr(langOf(message), "confirm.download"), "confirm:"+id),
+			tgbotapi.NewInlineKeyboardButtonData(tr(langOf(message), "confirm.cancel"), "reject:"+id),
+		),
+	)
+	prompt, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return
+	}
+
+	req := &pendingRequest{message: message, url: url, info: info, opts: opts, promptID: prompt.MessageID}
+	storePending(bot, id, req, text)
+}
+
+func takePending(id string) *pendingRequest {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	req, ok := pending[id]
+	if !ok {
+		return nil
+	}
+	delete(pending, id)
+	if req.timer != nil {
+		req.timer.Stop()
+	}
+	return req
+}
+
+// claimPending hands the request to whoever pressed the button, provided it's
+// the person who sent the link; nil means the press has been answered.
+func claimPending(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, id string) *pendingRequest {
+	pendingMu.Lock()
+	req, ok := pending[id]
+	pendingMu.Unlock()
+
+	if !ok {
+		answerCallback(bot, query, tr(callbackLanguage(query), "confirm.request_expired"))
+		return nil
+	}
+	if req.message.From != nil && query.From.ID != req.message.From.ID {
+		answerCallback(bot, query, tr(callbackLanguage(query), "confirm.not_yours"))
+		return nil
+	}
+	answerCallback(bot, query, "")
+	return takePending(id)
+}
+
+func handleConfirmCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, id string, confirmed bool) {
+	req := claimPending(bot, query, id)
+	if req == nil {
+		return
+	}
+
+	text := query.Message.Text
+	if confirmed {
+		text += "\n\n" + tr(langOf(req.message), "confirm.downloading")
+	} else {
+		text += "\n\n" + tr(langOf(req.message), "confirm.cancelled")
+	}
+	edit := tgbotapi.NewEditMessageText(req.message.Chat.ID, req.promptID, text)
+	if _, err := sendMessage(bot, edit); err != nil {
+		log.Println("Error updating prompt:", err)
+	}
+
+	if !confirmed {
+		return
+	}
+	if req.opts.Playlist {
+		processPlaylist(bot, req.message, req.url, req.opts)
+		return
+	}
+	processDownload(bot, req.message, req.url, req.info, req.opts)
+}
+
+func formatSize(bytes int64) string {
+	return fmt.Sprintf("%.1f MB", float64(bytes)/1024/1024)
+}
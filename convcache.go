@@ -0,0 +1,173 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const convertedCacheDirName = "converted"
+
+type convertedFile struct {
+	key  string
+	path string
+	name string // the file's name as it was sent
+	kbps int
+}
+
+// convertedCache keeps the last converted-cache-files finished files on disk,
+// so asking for the same video again at the same or a lower bitrate is served
+// without going back to yt-dlp. Entries are also on diskUsage's books as
+// finished artifacts, which may delete them to stay under the disk cap; a
+// missing file simply drops its entry.
+type convertedCache struct {
+	mu    sync.Mutex
+	order *list.List // front is most recently used
+	byKey map[string]*list.Element
+}
+
+var convertedFiles = &convertedCache{order: list.New(), byKey: make(map[string]*list.Element)}
+
+func convertedCacheDir() string {
+	return filepath.Join(conf.DownloadDir, convertedCacheDirName)
+}
+
+// convertedKey identifies a converted file regardless of bitrate. The file is
+// kept as the processed mp3, so the delivery format isn't part of the key
+// and is applied after take. Format downloads aren't converted and live
+// clips differ every time, so neither is cached.
+func convertedKey(chatID int64, info *videoInfo, opts downloadOptions) string {
+	if conf.ConvertedCacheFiles <= 0 || info == nil || info.ID == "" || opts.LiveClip > 0 || opts.FormatID != "" {
+		return ""
+	}
+	sampleRate, channels := audioOptionsFor(chatID)
+	return fmt.Sprintf("%s|%s|%s|%d|%d|%t|%t|%g", info.ID, info.Extractor, opts.Section, sampleRate, channels, trimSilenceFor(chatID), fadeFor(chatID), speedFor(chatID))
+}
+
+// take copies the cached file for key into dir, re-encoded to kbps if it was
+// stored at a higher bitrate.
+func (c *convertedCache) take(key string, kbps int, dir string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+
+	c.mu.Lock()
+	element, ok := c.byKey[key]
+	if !ok {
+		c.mu.Unlock()
+		return "", false
+	}
+	cached := *element.Value.(*convertedFile)
+	if cached.kbps < kbps {
+		c.mu.Unlock()
+		return "", false
+	}
+	if _, err := os.Stat(cached.path); err != nil {
+		c.removeLocked(element)
+		c.mu.Unlock()
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	c.mu.Unlock()
+
+	path := filepath.Join(dir, cached.name)
+	if err := linkOrCopy(cached.path, path); err != nil {
+		log.Println("Error reusing converted file:", err)
+		return "", false
+	}
+	if cached.kbps != kbps {
+		if err := reencodeFile(path, kbps, nil); err != nil {
+			log.Println("Error re-encoding converted file, downloading again:", err)
+			os.Remove(path)
+			return "", false
+		}
+	}
+
+	log.Printf("Reused converted file %s at %d kbps", cached.name, kbps)
+	return path, true
+}
+
+// put keeps a copy of the finished file at path under key, evicting the least
+// recently used entries beyond converted-cache-files.
+func (c *convertedCache) put(key string, path string, kbps int) {
+	if key == "" {
+		return
+	}
+
+	sum := sha1.Sum([]byte(key))
+	cachePath := filepath.Join(convertedCacheDir(), hex.EncodeToString(sum[:8])+filepath.Ext(path))
+	if err := os.MkdirAll(convertedCacheDir(), 0755); err != nil {
+		log.Println("Error creating converted cache directory:", err)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.byKey[key]; ok {
+		c.removeLocked(element)
+	}
+	if err := linkOrCopy(path, cachePath); err != nil {
+		log.Println("Error caching converted file:", err)
+		return
+	}
+	diskUsage.trackFinished(cachePath, time.Now())
+
+	c.byKey[key] = c.order.PushFront(&convertedFile{key: key, path: cachePath, name: filepath.Base(path), kbps: kbps})
+	for c.order.Len() > conf.ConvertedCacheFiles {
+		c.removeLocked(c.order.Back())
+	}
+}
+
+func (c *convertedCache) removeLocked(element *list.Element) {
+	cached := element.Value.(*convertedFile)
+	c.order.Remove(element)
+	delete(c.byKey, cached.key)
+	if err := os.Remove(cached.path); err != nil && !os.IsNotExist(err) {
+		log.Println("Error removing converted file:", err)
+	}
+	diskUsage.forget(cached.path)
+}
+
+func (c *convertedCache) has(path string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		if element.Value.(*convertedFile).path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// reapUnindexed removes files in the cache directory that no entry points to,
+// such as everything left from before a restart.
+func (c *convertedCache) reapUnindexed() (int, int64) {
+	paths, err := filepath.Glob(filepath.Join(globEscape(convertedCacheDir()), "*"))
+	if err != nil {
+		log.Println("Error listing converted cache:", err)
+		return 0, 0
+	}
+
+	var removed int
+	var reclaimed int64
+	for _, path := range paths {
+		if c.has(path) {
+			continue
+		}
+		size := pathSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Could not remove orphaned %s: %v", path, err)
+			continue
+		}
+		diskUsage.forget(path)
+		removed++
+		reclaimed += size
+	}
+	return removed, reclaimed
+}
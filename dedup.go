@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// sharedDownload is one in-flight download that identical requests attach
+// to instead of fetching the same video again.
+type sharedDownload struct {
+	done chan struct{}
+	path string
+	err  error
+
+	mu        sync.Mutex
+	listeners map[int]func(downloadProgress)
+	next      int
+
+	// copying counts followers still taking their copy of path, which the
+	// leader must not delete (by sending it) until they are done.
+	copying sync.WaitGroup
+}
+
+var sharedDownloads = struct {
+	sync.Mutex
+	byKey map[string]*sharedDownload
+}{byKey: make(map[string]*sharedDownload)}
+
+// downloadKey identifies requests that would produce byte-identical files.
+// It is empty when the video can't be identified, which disables sharing.
+// IDs are only unique per site, so the extractor is part of the key.
+func downloadKey(chatID int64, info *videoInfo, kbps int, opts downloadOptions) string {
+	if info == nil || info.ID == "" || opts.LiveClip > 0 {
+		return ""
+	}
+	sampleRate, channels := audioOptionsFor(chatID)
+	aac, aacQuality := aacFor(chatID)
+	return fmt.Sprintf("%s|%s|%d|%s|%s|%d|%d|%t|%t|%t|%g|%g", info.ID, info.Extractor, kbps, opts.Section, opts.FormatID, sampleRate, channels, trimSilenceFor(chatID), fadeFor(chatID), aac, aacQuality, speedFor(chatID))
+}
+
+// listen adds a progress callback and returns a func that removes it again;
+// nil callbacks are ignored.
+func (s *sharedDownload) listen(progress func(downloadProgress)) func() {
+	if progress == nil {
+		return func() {}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next++
+	id := s.next
+	s.listeners[id] = progress
+	return func() {
+		s.mu.Lock()
+		delete(s.listeners, id)
+		s.mu.Unlock()
+	}
+}
+
+// progress reports p to every request attached to the download.
+func (s *sharedDownload) progress(p downloadProgress) {
+	s.mu.Lock()
+	listeners := make([]func(downloadProgress), 0, len(s.listeners))
+	for _, listener := range s.listeners {
+		listeners = append(listeners, listener)
+	}
+	s.mu.Unlock()
+	for _, listener := range listeners {
+		listener(p)
+	}
+}
+
+// shareDownload runs fetch, unless an identical download is already running,
+// in which case it waits for that one and copies its result into dir. Either
+// way progress receives the updates of the download that actually runs. The
+// second return value reports whether the file came from another request.
+//
+// Cancelling job only gives up this request. When the request running the
+// download is cancelled instead, the ones waiting on it start over, one of
+// them taking its place.
+func shareDownload(key string, dir string, job *activeJob, progress func(downloadProgress), fetch func(progress func(downloadProgress)) (string, error)) (string, bool, error) {
+	if key == "" {
+		path, err := fetch(progress)
+		return path, false, err
+	}
+
+	sharedDownloads.Lock()
+	if shared, ok := sharedDownloads.byKey[key]; ok {
+		shared.copying.Add(1)
+		unlisten := shared.listen(progress)
+		sharedDownloads.Unlock()
+
+		select {
+		case <-shared.done:
+		case <-job.stop():
+			unlisten()
+			shared.copying.Done()
+			return "", true, errCancelled
+		}
+		if errors.Is(shared.err, errCancelled) && !job.isCancelled() {
+			shared.copying.Done()
+			log.Println("A shared download was cancelled, starting it again")
+			return shareDownload(key, dir, job, progress, fetch)
+		}
+		defer shared.copying.Done()
+		if shared.err != nil {
+			return "", true, shared.err
+		}
+		path := filepath.Join(dir, filepath.Base(shared.path))
+		return path, true, linkOrCopy(shared.path, path)
+	}
+	shared := &sharedDownload{done: make(chan struct{}), listeners: make(map[int]func(downloadProgress))}
+	shared.listen(progress)
+	sharedDownloads.byKey[key] = shared
+	sharedDownloads.Unlock()
+
+	shared.path, shared.err = fetch(shared.progress)
+
+	sharedDownloads.Lock()
+	delete(sharedDownloads.byKey, key)
+	sharedDownloads.Unlock()
+	close(shared.done)
+	shared.copying.Wait()
+
+	return shared.path, false, shared.err
+}
+
+// linkOrCopy copies src to dst, as a hard link when possible since job
+// directories normally share a filesystem.
+func linkOrCopy(src string, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("could not open %s: %v", filepath.Base(src), err)
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return fmt.Errorf("could not copy %s: %v", filepath.Base(src), err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return fmt.Errorf("could not copy %s: %v", filepath.Base(src), err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(dst)
+		return fmt.Errorf("could not copy %s: %v", filepath.Base(src), err)
+	}
+	return nil
+}
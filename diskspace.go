@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	diskSpaceSafetyFactor = 1.5
+	lowDiskNotifyEvery    = time.Hour
+)
+
+var lowDiskNotified struct {
+	sync.Mutex
+	at time.Time
+}
+
+// requiredDiskSpace estimates what a download needs on disk: the mp3 itself
+// plus the yt-dlp source it is extracted from, which is around the same size.
+func requiredDiskSpace(info *videoInfo, kbps int) int64 {
+	if info == nil || info.Duration <= 0 {
+		return 2 * maxFileSize
+	}
+	return int64(2 * diskSpaceSafetyFactor * float64(estimateSize(info.Duration, kbps)))
+}
+
+// checkDiskSpace returns a message for the user when the download volume
+// can't hold the job, and lets the admin chat know.
+func checkDiskSpace(bot *tgbotapi.BotAPI, lang string, info *videoInfo, kbps int) string {
+	free, err := freeDiskSpace(conf.DownloadDir)
+	if err != nil {
+		log.Println("Could not check free disk space:", err)
+		return ""
+	}
+
+	needed := requiredDiskSpace(info, kbps)
+	if free >= needed {
+		return ""
+	}
+
+	log.Printf("Not enough disk space: %s free, %s needed", formatSize(free), formatSize(needed))
+	notifyLowDiskSpace(bot, free, needed)
+	return tr(lang, "download.no_disk_space")
+}
+
+func notifyLowDiskSpace(bot *tgbotapi.BotAPI, free int64, needed int64) {
+	if conf.AdminChatID == 0 {
+		return
+	}
+
+	lowDiskNotified.Lock()
+	if time.Since(lowDiskNotified.at) < lowDiskNotifyEvery {
+		lowDiskNotified.Unlock()
+		return
+	}
+	lowDiskNotified.at = time.Now()
+	lowDiskNotified.Unlock()
+
+	sendText(bot, conf.AdminChatID, tr(chatLanguage(conf.AdminChatID), "disk.low", conf.DownloadDir, formatSize(free), formatSize(needed)))
+}
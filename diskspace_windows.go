@@ -0,0 +1,8 @@
+package main
+
+import "math"
+
+// Free space isn't checked on Windows; report plenty so downloads proceed.
+func freeDiskSpace(dir string) (int64, error) {
+	return math.MaxInt64, nil
+}
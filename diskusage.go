@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type diskEntry struct {
+	size     int64
+	finished bool
+	at       time.Time
+}
+
+// diskAccounting keeps a running tally of what the bot has on disk: the space
+// reserved by running jobs and the size of finished job directories that
+// were kept around, so max-disk-usage-mb can be enforced without walking
+// download-dir for every request.
+type diskAccounting struct {
+	mu      sync.Mutex
+	entries map[string]*diskEntry
+}
+
+var diskUsage = &diskAccounting{entries: make(map[string]*diskEntry)}
+
+func (d *diskAccounting) track(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.entries[filepath.Clean(path)] = &diskEntry{at: time.Now()}
+}
+
+// trackFinished records a leftover directory found on disk, e.g. at startup.
+// Paths already on the books are left alone so they're only measured once.
+func (d *diskAccounting) trackFinished(path string, at time.Time) {
+	path = filepath.Clean(path)
+	d.mu.Lock()
+	_, known := d.entries[path]
+	d.mu.Unlock()
+	if known {
+		return
+	}
+
+	size := pathSize(path)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, known := d.entries[path]; !known {
+		d.entries[path] = &diskEntry{size: size, finished: true, at: at}
+	}
+}
+
+// reserve sets aside needed bytes for the running job in path. When that would
+// go over the cap, the oldest finished artifacts are deleted first; false
+// means even that didn't free enough.
+func (d *diskAccounting) reserve(path string, needed int64) bool {
+	limit := int64(conf.MaxDiskUsageMB) * 1024 * 1024
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path = filepath.Clean(path)
+	entry, ok := d.entries[path]
+	if !ok {
+		entry = &diskEntry{at: time.Now()}
+		d.entries[path] = entry
+	}
+	if limit <= 0 {
+		entry.size = needed
+		return true
+	}
+
+	used := d.totalLocked() - entry.size
+	if used+needed > limit {
+		used -= d.evictLocked(used + needed - limit)
+	}
+	if used+needed > limit {
+		return false
+	}
+
+	entry.size = needed
+	return true
+}
+
+// finish is called when a job ends; kept directories stay on the books at
+// their real size so they can be evicted later.
+func (d *diskAccounting) finish(path string, removed bool) {
+	path = filepath.Clean(path)
+	size := int64(0)
+	if !removed {
+		size = pathSize(path)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if removed {
+		delete(d.entries, path)
+		return
+	}
+	d.entries[path] = &diskEntry{size: size, finished: true, at: time.Now()}
+}
+
+func (d *diskAccounting) forget(path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.entries, filepath.Clean(path))
+}
+
+func (d *diskAccounting) totalLocked() int64 {
+	var total int64
+	for _, entry := range d.entries {
+		total += entry.size
+	}
+	return total
+}
+
+// evictLocked removes finished artifacts, oldest first, until at least want
+// bytes are freed, and returns how much it freed.
+func (d *diskAccounting) evictLocked(want int64) int64 {
+	var finished []string
+	for path, entry := range d.entries {
+		if entry.finished {
+			finished = append(finished, path)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return d.entries[finished[i]].at.Before(d.entries[finished[j]].at)
+	})
+
+	var freed int64
+	for _, path := range finished {
+		if freed >= want {
+			break
+		}
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Could not remove %s to stay under the disk cap: %v", path, err)
+			continue
+		}
+		log.Printf("Removed %s (%s) to stay under the disk cap", path, formatSize(d.entries[path].size))
+		freed += d.entries[path].size
+		delete(d.entries, path)
+	}
+	return freed
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// The public Bot API caps documents at 50 MB as well; a local Bot API server
+// accepts up to 2000 MB.
+const (
+	publicMaxDocumentSizeMB = 50
+	localMaxDocumentSizeMB  = 2000
+)
+
+// apiEndpoint turns the api-endpoint setting into the format the library
+// expects, or the public Bot API's when it's empty.
+func apiEndpoint(base string) string {
+	if base == "" {
+		return tgbotapi.APIEndpoint
+	}
+	return strings.TrimSuffix(base, "/") + "/bot%s/%s"
+}
+
+func validateAPIEndpoint(endpoint string) error {
+	parsed, err := neturl.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", parsed.Scheme)
+	}
+	if parsed.Hostname() == "" {
+		return fmt.Errorf("missing host")
+	}
+	return nil
+}
+
+// canSendAsDocument reports whether a file too big for an audio message may
+// go out whole as a document instead of being split.
+func canSendAsDocument(size int64) bool {
+	return conf.PreferDocument && size <= int64(conf.MaxDocumentSizeMB)*1024*1024
+}
+
+func sendDocument(bot *tgbotapi.BotAPI, filePath string, chatID int64, meta trackMeta) error {
+	stopAction := startChatAction(bot, chatID, tgbotapi.ChatUploadDocument)
+	defer stopAction()
+	_, err := sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open file: %v", err)
+		}
+
+		document := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: filepath.Base(filePath), Reader: file})
+		document.Caption, document.ParseMode = meta.captionFor(int(meta.Duration), fileSize(filePath))
+		document.ReplyToMessageID = meta.ReplyTo
+		return document, func() { file.Close() }, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	removeTempFile(filePath)
+	return nil
+}
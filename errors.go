@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os/exec"
+	"strings"
+)
+
+type downloadErrorKind int
+
+const (
+	errUnknown downloadErrorKind = iota
+	errPrivate
+	errGeoBlocked
+	errRemoved
+	errAgeRestricted
+	errLiveStream
+	errMembersOnly
+	errLoginRequired
+	errNoVideo
+	errTerminated
+	errCopyright
+	errUnavailable
+	errNetwork
+)
+
+type execErrorKind int
+
+const (
+	execOK execErrorKind = iota
+	execNotFound
+	execNonZeroExit
+	execKilled
+	execOther
+)
+
+type execResult struct {
+	Kind     execErrorKind
+	ExitCode int
+	Output   string
+	Err      error
+}
+
+func (r execResult) String() string {
+	switch r.Kind {
+	case execOK:
+		return "ok"
+	case execNotFound:
+		return "command not found"
+	case execNonZeroExit:
+		return fmt.Sprintf("exit status %d", r.ExitCode)
+	case execKilled:
+		return fmt.Sprintf("killed (%v)", r.Err)
+	default:
+		return fmt.Sprintf("failed to run: %v", r.Err)
+	}
+}
+
+func classifyExecError(err error, output []byte) execResult {
+	result := execResult{Output: string(output), Err: err}
+	if err == nil {
+		return result
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case errors.Is(err, exec.ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		result.Kind = execNotFound
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+		// ExitCode is -1 when the process was terminated by a signal, which
+		// is also what a context timeout does.
+		if result.ExitCode == -1 {
+			result.Kind = execKilled
+		} else {
+			result.Kind = execNonZeroExit
+		}
+	default:
+		result.Kind = execOther
+	}
+
+	return result
+}
+
+type downloadError struct {
+	Kind   downloadErrorKind
+	Exec   execResult
+	Output string
+	Err    error
+}
+
+func (e *downloadError) Error() string {
+	return fmt.Sprintf("yt-dlp failed: %s", e.Exec)
+}
+
+func (e *downloadError) Unwrap() error {
+	return e.Err
+}
+
+// Checked in order, so more specific patterns must come before generic
+// ones like "video unavailable".
+var ytDlpErrorPatterns = []struct {
+	kind     downloadErrorKind
+	patterns []string
+}{
+	{errPrivate, []string{"private video"}},
+	{errMembersOnly, []string{"members-only", "join this channel to get access", "available to this channel's members"}},
+	{errAgeRestricted, []string{"sign in to confirm your age", "age-restricted", "inappropriate for some users"}},
+	{errGeoBlocked, []string{"available in your country", "geo restriction", "geo-restricted", "blocked it in your country"}},
+	{errLoginRequired, []string{"login required", "log in to", "login to", "use --cookies", "cookies for the authentication"}},
+	{errNoVideo, []string{"no video could be found", "there is no video in this post", "no video formats found"}},
+	{errLiveStream, []string{"this live event will begin", "premieres in", "is currently live", "live stream recording is not available"}},
+	{errCopyright, []string{"copyright claim", "copyright grounds", "copyright infringement"}},
+	{errTerminated, []string{"account associated with this video has been terminated", "channel has been terminated", "has been terminated"}},
+	{errRemoved, []string{"has been removed", "no longer available"}},
+	{errUnavailable, []string{"video unavailable", "this video is not available", "content isn't available"}},
+	{errNetwork, []string{"timed out", "connection reset", "connection refused", "network is unreachable", "temporary failure in name resolution", "name or service not known", "http error 5"}},
+}
+
+func classifyYtDlpError(output string) downloadErrorKind {
+	lower := strings.ToLower(output)
+	for _, entry := range ytDlpErrorPatterns {
+		for _, pattern := range entry.patterns {
+			if strings.Contains(lower, pattern) {
+				return entry.kind
+			}
+		}
+	}
+	return errUnknown
+}
+
+func userErrorMessage(lang string, err error) string {
+	if errors.Is(err, errCancelled) {
+		return tr(lang, "download.cancelled")
+	}
+	if errors.Is(err, errTooManyFiles) {
+		return trn(lang, "error.too_many_files", maxOutputFiles())
+	}
+	if errors.Is(err, errStillLive) {
+		return tr(lang, "error.live")
+	}
+	var dlErr *downloadError
+	if !errors.As(err, &dlErr) {
+		return tr(lang, "error.generic")
+	}
+
+	switch dlErr.Exec.Kind {
+	case execNotFound:
+		return tr(lang, "error.not_installed")
+	case execKilled:
+		return tr(lang, "error.killed")
+	}
+
+	switch dlErr.Kind {
+	case errPrivate:
+		return tr(lang, "error.private")
+	case errGeoBlocked:
+		return tr(lang, "error.geo_blocked")
+	case errRemoved:
+		return tr(lang, "error.removed")
+	case errAgeRestricted:
+		return tr(lang, "error.age_restricted")
+	case errLiveStream:
+		return tr(lang, "error.live")
+	case errMembersOnly:
+		return tr(lang, "error.members_only")
+	case errLoginRequired:
+		if conf.CookiesFile == "" {
+			return tr(lang, "error.login_required")
+		}
+		return tr(lang, "error.cookies_rejected")
+	case errNoVideo:
+		return tr(lang, "error.no_video")
+	case errTerminated:
+		return tr(lang, "error.terminated")
+	case errCopyright:
+		return tr(lang, "error.copyright")
+	case errUnavailable:
+		return tr(lang, "error.unavailable")
+	case errNetwork:
+		return tr(lang, "error.network")
+	default:
+		return tr(lang, "error.generic")
+	}
+}
+
+// failureReason is a short label for err, for summaries listing many failures.
+func failureReason(lang string, err error) string {
+	if errors.Is(err, errTooManyFiles) {
+		return tr(lang, "reason.too_many_files")
+	}
+	if errors.Is(err, errStillLive) {
+		return tr(lang, "reason.live")
+	}
+	var dlErr *downloadError
+	if !errors.As(err, &dlErr) {
+		return tr(lang, "reason.error")
+	}
+
+	switch dlErr.Kind {
+	case errPrivate:
+		return tr(lang, "reason.private")
+	case errGeoBlocked:
+		return tr(lang, "reason.geo_blocked")
+	case errRemoved:
+		return tr(lang, "reason.removed")
+	case errAgeRestricted:
+		return tr(lang, "reason.age_restricted")
+	case errLiveStream:
+		return tr(lang, "reason.live")
+	case errMembersOnly:
+		return tr(lang, "reason.members_only")
+	case errLoginRequired:
+		return tr(lang, "reason.login_required")
+	case errNoVideo:
+		return tr(lang, "reason.no_video")
+	case errTerminated:
+		return tr(lang, "reason.terminated")
+	case errCopyright:
+		return tr(lang, "reason.copyright")
+	case errUnavailable:
+		return tr(lang, "reason.unavailable")
+	case errNetwork:
+		return tr(lang, "reason.network")
+	default:
+		return tr(lang, "reason.failed")
+	}
+}
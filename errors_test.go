@@ -0,0 +1,43 @@
+package main
+
+import "testing"
+
+func TestClassifyYtDlpError(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   downloadErrorKind
+	}{
+		{"private", "ERROR: [youtube] dQw4w9WgXcQ: Private video. Sign in if you've been granted access to this video", errPrivate},
+		{"members only", "ERROR: [youtube] dQw4w9WgXcQ: Join this channel to get access to members-only content like this video, and other exclusive perks.", errMembersOnly},
+		{"age restricted", "ERROR: [youtube] dQw4w9WgXcQ: Sign in to confirm your age. This video may be inappropriate for some users.", errAgeRestricted},
+		{"geo blocked", "ERROR: [youtube] dQw4w9WgXcQ: The uploader has not made this video available in your country", errGeoBlocked},
+		{"geo restriction", "ERROR: [vimeo] 12345: This video is not available from your location due to geo restriction", errGeoBlocked},
+		{"terminated", "ERROR: [youtube] dQw4w9WgXcQ: This video is no longer available because the YouTube account associated with this video has been terminated.", errTerminated},
+		{"copyright", "ERROR: [youtube] dQw4w9WgXcQ: This video is no longer available due to a copyright claim by Some Label", errCopyright},
+		{"removed", "ERROR: [youtube] dQw4w9WgXcQ: This video has been removed by the uploader", errRemoved},
+		{"unavailable", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable", errUnavailable},
+		{"network", "ERROR: [youtube] dQw4w9WgXcQ: Unable to download API page: <urlopen error [Errno -3] Temporary failure in name resolution>", errNetwork},
+		{"server error", "ERROR: unable to download video data: HTTP Error 503: Service Unavailable", errNetwork},
+		{"case insensitive", "ERROR: PRIVATE VIDEO", errPrivate},
+		{"unknown", "ERROR: Unsupported URL: https://example.com/", errUnknown},
+		{"empty", "", errUnknown},
+
+		// These match more than one pattern and only come out right because
+		// the more specific one is checked first.
+		{"geo before unavailable", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. The uploader has not made this video available in your country", errGeoBlocked},
+		{"terminated before removed", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. This video is no longer available because the YouTube account associated with this video has been terminated.", errTerminated},
+		{"copyright before removed", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. This video is no longer available due to a copyright claim by Some Label", errCopyright},
+		{"removed before unavailable", "ERROR: [youtube] dQw4w9WgXcQ: Video unavailable. This video has been removed by the uploader", errRemoved},
+		{"age before login", "ERROR: [youtube] dQw4w9WgXcQ: Sign in to confirm your age. Use --cookies-from-browser or --cookies for the authentication.", errAgeRestricted},
+		{"members before login", "ERROR: [youtube] dQw4w9WgXcQ: This video is available to this channel's members on level: Tier 1. Use --cookies for the authentication.", errMembersOnly},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyYtDlpError(tt.output); got != tt.want {
+				t.Errorf("classifyYtDlpError(%q) = %v, want %v", tt.output, got, tt.want)
+			}
+		})
+	}
+}
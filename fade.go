@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultFadeSeconds = 0.5
+
+func fadeFor(chatID int64) bool {
+	if enabled := prefs.get(chatID).Fade; enabled != nil {
+		return *enabled
+	}
+	return conf.FadeClips
+}
+
+// fadeClip fades the start and end of a clipped download in place, so a
+// section cut out of a longer video doesn't start and stop abruptly.
+func fadeClip(filePath string, kbps int, job *activeJob) error {
+	fade := *conf.FadeSeconds
+	if fade == 0 {
+		return nil
+	}
+	duration, err := probeDuration(filePath)
+	if err != nil {
+		return err
+	}
+	// Very short clips would fade out before they faded in.
+	fade = min(fade, duration/2)
+	filter := fmt.Sprintf("afade=t=in:st=0:d=%.3f,afade=t=out:st=%.3f:d=%.3f", fade, duration-fade, fade)
+
+	ext := filepath.Ext(filePath)
+	outputPath := strings.TrimSuffix(filePath, ext) + ".fade" + ext
+	output, err := job.run(exec.Command("ffmpeg", "-i", filePath, "-vn", "-af", filter, "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", kbps), outputPath))
+	if err != nil {
+		log.Printf("Error fading clip with ffmpeg: %s\n%s", err, string(output))
+		os.Remove(outputPath)
+		return fmt.Errorf("could not fade clip: %v", err)
+	}
+
+	return os.Rename(outputPath, filePath)
+}
+
+func handleFadeSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if fadeFor(message.Chat.ID) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "fade.current_on"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "fade.current_off"))
+		}
+		return
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "fade.usage"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.Fade = &enabled
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if enabled {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "fade.on"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "fade.off"))
+	}
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// cachedUpload is a file Telegram already has, which can be sent again to any
+// chat by file_id without downloading anything. Split files keep one file_id
+// per part.
+type cachedUpload struct {
+	VideoID  string    `json:"video-id"`
+	Format   string    `json:"format"`
+	FileIDs  []string  `json:"file-ids"`
+	Title    string    `json:"title"`
+	Bitrate  int       `json:"bitrate"`
+	Size     int64     `json:"size"`
+	Duration float64   `json:"duration"`
+	Note     string    `json:"note,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+func (c *cachedUpload) complete() bool {
+	for _, id := range c.FileIDs {
+		if id == "" {
+			return false
+		}
+	}
+	return len(c.FileIDs) > 0
+}
+
+var uploadCacheBucket = []byte("uploads")
+
+// uploadCacheStore keeps cached uploads in a bbolt database keyed by
+// downloadKey, opened the first time the cache is used so startup doesn't
+// wait on it. New file_ids are held in pending and written out once per job
+// by flushUploadCache rather than after every part. Without a database,
+// pending is all there is and the cache lasts until the bot restarts.
+type uploadCacheStore struct {
+	mu      sync.Mutex
+	path    string
+	opened  bool
+	db      *bolt.DB
+	pending map[string]*cachedUpload
+}
+
+var uploadCache = &uploadCacheStore{}
+
+func (s *uploadCacheStore) open() {
+	if s.opened {
+		return
+	}
+	s.opened = true
+	s.pending = make(map[string]*cachedUpload)
+	if s.path == "" {
+		return
+	}
+
+	db, err := bolt.Open(s.path, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Println("Error opening upload cache, keeping it in memory:", err)
+		return
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(uploadCacheBucket)
+		return err
+	})
+	if err != nil {
+		log.Println("Error preparing upload cache, keeping it in memory:", err)
+		db.Close()
+		return
+	}
+	s.db = db
+}
+
+// get returns the entry for key, or nil; changes to it are kept only once it
+// is put back in pending.
+func (s *uploadCacheStore) get(key string) *cachedUpload {
+	if c, ok := s.pending[key]; ok {
+		return c
+	}
+	if s.db == nil {
+		return nil
+	}
+
+	var c *cachedUpload
+	err := s.db.View(func(tx *bolt.Tx) error {
+		raw := tx.Bucket(uploadCacheBucket).Get([]byte(key))
+		if raw == nil {
+			return nil
+		}
+		c = &cachedUpload{}
+		return json.Unmarshal(raw, c)
+	})
+	if err != nil {
+		log.Println("Error reading upload cache:", err)
+		return nil
+	}
+	return c
+}
+
+// all returns every entry, written or pending.
+func (s *uploadCacheStore) all() map[string]*cachedUpload {
+	entries := make(map[string]*cachedUpload)
+	if s.db != nil {
+		err := s.db.View(func(tx *bolt.Tx) error {
+			return tx.Bucket(uploadCacheBucket).ForEach(func(key, raw []byte) error {
+				c := &cachedUpload{}
+				if err := json.Unmarshal(raw, c); err != nil {
+					log.Printf("Skipping unreadable upload cache entry %s: %v", key, err)
+					return nil
+				}
+				entries[string(key)] = c
+				return nil
+			})
+		})
+		if err != nil {
+			log.Println("Error reading upload cache:", err)
+		}
+	}
+	for key, c := range s.pending {
+		entries[key] = c
+	}
+	return entries
+}
+
+func (s *uploadCacheStore) remove(keys ...string) {
+	for _, key := range keys {
+		delete(s.pending, key)
+	}
+	if s.db == nil || len(keys) == 0 {
+		return
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(uploadCacheBucket)
+		for _, key := range keys {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("Error writing upload cache:", err)
+	}
+}
+
+func (s *uploadCacheStore) flush() {
+	if s.db == nil || len(s.pending) == 0 {
+		return
+	}
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(uploadCacheBucket)
+		for key, c := range s.pending {
+			raw, err := json.Marshal(c)
+			if err != nil {
+				return fmt.Errorf("could not encode %s: %v", key, err)
+			}
+			if err := bucket.Put([]byte(key), raw); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Left pending, to be written with the next job's.
+		log.Println("Error writing upload cache:", err)
+		return
+	}
+	s.pending = make(map[string]*cachedUpload)
+}
+
+// cachedUploadFor returns the cached upload for key once every part of it has
+// been sent successfully.
+func cachedUploadFor(key string) (cachedUpload, bool) {
+	if key == "" {
+		return cachedUpload{}, false
+	}
+
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+	c := uploadCache.get(key)
+	if c == nil || !c.complete() {
+		return cachedUpload{}, false
+	}
+	cached := *c
+	cached.FileIDs = append([]string(nil), c.FileIDs...)
+	return cached, true
+}
+
+// rememberUpload records the file_id Telegram assigned to a sent file or part.
+func rememberUpload(meta trackMeta, sent tgbotapi.Message) {
+	if meta.CacheKey == "" || sent.Audio == nil {
+		return
+	}
+	parts := max(meta.Parts, 1)
+	index := max(meta.Part, 1) - 1
+
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+	c := uploadCache.get(meta.CacheKey)
+	if c == nil || len(c.FileIDs) != parts {
+		videoID, _, _ := strings.Cut(meta.CacheKey, "|")
+		c = &cachedUpload{VideoID: videoID, Format: sent.Audio.MimeType, FileIDs: make([]string, parts), Title: meta.Title, Bitrate: meta.Bitrate, Duration: meta.Duration, Note: meta.Note, Created: time.Now()}
+	}
+	c.FileIDs[index] = sent.Audio.FileID
+	c.Size += int64(sent.Audio.FileSize)
+	uploadCache.pending[meta.CacheKey] = c
+}
+
+// resetUpload forgets what was recorded for key before the file is uploaded
+// anew, so the parts of an earlier upload don't add to its size.
+func resetUpload(key string) {
+	if key == "" {
+		return
+	}
+	forgetUpload(key)
+}
+
+// flushUploadCache writes out the file_ids recorded since the last flush.
+func flushUploadCache() {
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+	uploadCache.flush()
+}
+
+func forgetUpload(key string) {
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+	uploadCache.remove(key)
+}
+
+// evictVideo drops every cached upload of videoID, whatever its bitrate or
+// format, and returns how many there were.
+func evictVideo(videoID string) int {
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+
+	var keys []string
+	for key := range uploadCache.all() {
+		if strings.HasPrefix(key, videoID+"|") {
+			keys = append(keys, key)
+		}
+	}
+	uploadCache.remove(keys...)
+	return len(keys)
+}
+
+func uploadCacheStats(lang string) string {
+	uploadCache.mu.Lock()
+	defer uploadCache.mu.Unlock()
+	uploadCache.open()
+
+	entries := uploadCache.all()
+	videos := make(map[string]bool)
+	files := 0
+	var oldest time.Time
+	for key, c := range entries {
+		id, _, _ := strings.Cut(key, "|")
+		videos[id] = true
+		files += len(c.FileIDs)
+		if oldest.IsZero() || c.Created.Before(oldest) {
+			oldest = c.Created
+		}
+	}
+
+	text := tr(lang, "cache.stats", len(entries), len(videos), files)
+	if !oldest.IsZero() {
+		text += "\n" + tr(lang, "cache.oldest", oldest.Format("2006-01-02 15:04"))
+	}
+	return text
+}
+
+func handleCacheCommand(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+	switch {
+	case len(fields) == 0 || fields[0] == "stats":
+		sendText(bot, message.Chat.ID, uploadCacheStats(langOf(message)))
+	case fields[0] == "evict" && len(fields) == 2:
+		evicted := evictVideo(fields[1])
+		if evicted == 0 {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "cache.nothing", fields[1]))
+			return
+		}
+		log.Printf("Evicted %d cached upload(s) of %s", evicted, fields[1])
+		sendText(bot, message.Chat.ID, trn(langOf(message), "cache.evicted", evicted, fields[1]))
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "cache.usage"))
+	}
+}
+
+// sendCachedUpload resends a cached upload to chatID. If Telegram rejects a
+// file_id the entry is dropped so the caller can fall back to downloading.
+// sendCachedUpload sends the parts in order and returns how many of them
+// reached the chat, which is fewer than all of them only with an error.
+func sendCachedUpload(bot *tgbotapi.BotAPI, chatID int64, cached cachedUpload, meta trackMeta) (int, error) {
+	meta.Note = cached.Note
+	if len(cached.FileIDs) > 1 {
+		meta.Parts = len(cached.FileIDs)
+	}
+
+	// Only a single file's size and length are known.
+	var duration int
+	var size int64
+	if len(cached.FileIDs) == 1 {
+		duration, size = int(math.Round(cached.Duration)), cached.Size
+	}
+
+	for i, fileID := range cached.FileIDs {
+		partMeta := meta
+		if partMeta.Parts > 0 {
+			partMeta.Part = i + 1
+		}
+		_, err := sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+			audioFile := tgbotapi.NewAudio(chatID, tgbotapi.FileID(fileID))
+			audioFile.Caption, audioFile.ParseMode = partMeta.captionFor(duration, size)
+			audioFile.Title = partMeta.audioTitle()
+			audioFile.Performer = partMeta.Uploader
+			audioFile.ReplyToMessageID = partMeta.ReplyTo
+			return audioFile, nil, nil
+		})
+		if err != nil {
+			forgetUpload(meta.CacheKey)
+			if i == 0 {
+				return 0, fmt.Errorf("could not resend cached file: %v", err)
+			}
+			return i, fmt.Errorf("could not resend cached part %d of %d: %v", i+1, len(cached.FileIDs), err)
+		}
+	}
+
+	log.Printf("Sent %s from the file_id cache", meta.URL)
+	return len(cached.FileIDs), nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUploadCachePersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upload-cache.db")
+	saved := uploadCache
+	defer func() { uploadCache = saved }()
+	uploadCache = &uploadCacheStore{path: path}
+
+	send := func(key string, part, parts int, fileID string) {
+		meta := trackMeta{Title: "Song", Bitrate: 192, Duration: 200, Part: part, Parts: parts, CacheKey: key}
+		rememberUpload(meta, tgbotapi.Message{Audio: &tgbotapi.Audio{FileID: fileID, FileSize: 1000, MimeType: "audio/mpeg"}})
+	}
+	send("abc|youtube|192", 0, 0, "file-1")
+	send("abc|youtube|128", 1, 2, "part-1")
+	send("abc|youtube|128", 2, 2, "part-2")
+	send("xyz|youtube|192", 0, 0, "file-2")
+	flushUploadCache()
+	uploadCache.db.Close()
+
+	// A restart opens the same database again.
+	uploadCache = &uploadCacheStore{path: path}
+	cached, ok := cachedUploadFor("abc|youtube|128")
+	if !ok {
+		t.Fatal("split upload not found after reopening")
+	}
+	if len(cached.FileIDs) != 2 || cached.FileIDs[1] != "part-2" || cached.Size != 2000 {
+		t.Fatalf("cached = %+v, want two parts of 2000 bytes", cached)
+	}
+	if cached.VideoID != "abc" || cached.Format != "audio/mpeg" || cached.Title != "Song" || cached.Created.IsZero() {
+		t.Fatalf("cached = %+v, want its video, format, title and creation time", cached)
+	}
+
+	if evicted := evictVideo("abc"); evicted != 2 {
+		t.Fatalf("evictVideo = %d, want 2", evicted)
+	}
+	if _, ok := cachedUploadFor("abc|youtube|192"); ok {
+		t.Fatal("evicted upload still cached")
+	}
+	if _, ok := cachedUploadFor("xyz|youtube|192"); !ok {
+		t.Fatal("another video's upload was evicted")
+	}
+	uploadCache.db.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+const defaultMaxOutputFiles = 200
+
+var errTooManyFiles = errors.New("the request would produce too many files")
+
+func maxOutputFiles() int {
+	if conf.MaxOutputFiles <= 0 {
+		return defaultMaxOutputFiles
+	}
+	return conf.MaxOutputFiles
+}
+
+// fileBudget counts the files one request produces, downloads and split
+// parts alike, so neither a huge playlist nor a file cut into countless
+// parts can fill the disk.
+type fileBudget struct {
+	mu    sync.Mutex
+	limit int
+	used  int
+}
+
+func newFileBudget() *fileBudget {
+	return &fileBudget{limit: maxOutputFiles()}
+}
+
+// take accounts for n more files, or returns errTooManyFiles if that would
+// go over the limit. A nil budget never runs out.
+func (b *fileBudget) take(n int) error {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.used+n > b.limit {
+		return errTooManyFiles
+	}
+	b.used += n
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func (f videoFormat) hasAudio() bool {
+	return f.ACodec != "" && f.ACodec != "none"
+}
+
+func (f videoFormat) audioOnly() bool {
+	return f.hasAudio() && (f.VCodec == "" || f.VCodec == "none")
+}
+
+func (f videoFormat) size() int64 {
+	if f.Filesize > 0 {
+		return f.Filesize
+	}
+	return f.FilesizeApprox
+}
+
+func findFormat(info *videoInfo, id string) (videoFormat, bool) {
+	for _, format := range info.Formats {
+		if format.FormatID == id {
+			return format, true
+		}
+	}
+	return videoFormat{}, false
+}
+
+func handleFormats(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	url := strings.TrimSpace(args)
+	if !isValidYouTubeURL(url) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "formats.usage"))
+		return
+	}
+
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), err))
+		return
+	}
+
+	var sb strings.Builder
+	sb.WriteString(tr(langOf(message), "formats.header", info.Title) + "\n")
+	count := 0
+	for _, format := range info.Formats {
+		if !format.hasAudio() {
+			continue
+		}
+		kind := tr(langOf(message), "formats.audio_video")
+		if format.audioOnly() {
+			kind = tr(langOf(message), "formats.audio_only")
+		}
+		line := fmt.Sprintf("%s · %s · %s · %.0f kbps", format.FormatID, format.Ext, kind, format.ABR)
+		if size := format.size(); size > 0 {
+			line += " · " + formatSize(size)
+		}
+		line += "\n"
+		if sb.Len()+len(line) > maxChapterListLength {
+			sb.WriteString("…\n")
+			break
+		}
+		sb.WriteString(line)
+		count++
+	}
+	if count == 0 {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "formats.none"))
+		return
+	}
+	sb.WriteString("\n" + tr(langOf(message), "formats.hint", url))
+	sendText(bot, message.Chat.ID, sb.String())
+}
+
+func handleFormatID(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+	if len(fields) != 2 || !isValidYouTubeURL(fields[0]) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "formatid.usage"))
+		return
+	}
+	url, id := fields[0], fields[1]
+
+	if reason := checkQuota(message); reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), err))
+		return
+	}
+	if reason := checkDurationLimit(langOf(message), info); reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+
+	format, ok := findFormat(info, id)
+	if !ok {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "formatid.missing", id, url))
+		return
+	}
+	if !format.hasAudio() {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "formatid.no_audio", id))
+		return
+	}
+
+	processDownload(bot, message, url, info, downloadOptions{FormatID: id})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func isGroupChat(chat *tgbotapi.Chat) bool {
+	return chat != nil && (chat.IsGroup() || chat.IsSuperGroup())
+}
+
+// addedToChat reports whether message announces the bot joining its chat.
+func addedToChat(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	for _, member := range message.NewChatMembers {
+		if member.ID == bot.Self.ID {
+			return true
+		}
+	}
+	return message.GroupChatCreated || message.SuperGroupChatCreated
+}
+
+func greetGroup(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if conf.GroupGreeting == "" {
+		return
+	}
+	log.Printf("Added to group %d", message.Chat.ID)
+	sendText(bot, message.Chat.ID, conf.GroupGreeting)
+}
+
+// isForOtherBot reports whether a command was addressed to another bot, as
+// in /help@otherbot, which in groups is none of this bot's business.
+func isForOtherBot(bot *tgbotapi.BotAPI, message *tgbotapi.Message) bool {
+	_, target, ok := strings.Cut(message.CommandWithAt(), "@")
+	return ok && !strings.EqualFold(target, bot.Self.UserName)
+}
+
+// groupRequest finds what a group message asks of the bot. Links may appear
+// anywhere in the text rather than only at its start, but keywords count
+// only when nothing but keywords follows the link, so that a link shared
+// with a comment after it isn't taken for an order. mentioned tells whether
+// the message named the bot, and so deserves a reply even if it has no
+// usable link.
+func groupRequest(bot *tgbotapi.BotAPI, text string) (request string, mentioned bool) {
+	mention := "@" + bot.Self.UserName
+	var fields []string
+	for _, field := range strings.Fields(text) {
+		if strings.EqualFold(field, mention) {
+			mentioned = true
+			continue
+		}
+		fields = append(fields, field)
+	}
+
+	for i, field := range fields {
+		if isSupportedURL(field) || isPlaylistURL(field) {
+			for _, word := range fields[i+1:] {
+				if !isRequestKeyword(word) {
+					return field, mentioned
+				}
+			}
+			return strings.Join(fields[i:], " "), mentioned
+		}
+	}
+	return strings.Join(fields, " "), mentioned
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestGroupRequest(t *testing.T) {
+	savedConf := conf
+	defer func() { conf = savedConf }()
+	conf = &Config{}
+
+	bot := &tgbotapi.BotAPI{Self: tgbotapi.User{UserName: "mp3bot"}}
+	link := "https://www.youtube.com/watch?v=dQw4w9WgXcQ"
+	tests := []struct {
+		name      string
+		text      string
+		want      string
+		mentioned bool
+	}{
+		{"link only", link, link, false},
+		{"keywords after the link", link + " force zip", link + " force zip", false},
+		{"link in prose", "have a listen " + link, link, false},
+		{"prose after the link", link + " force yourself to merge this into your zip playlist", link, false},
+		{"keyword then prose", link + " zip it up later", link, false},
+		{"mention", "@mp3bot " + link + " force", link + " force", true},
+		{"no link", "@mp3bot hello there", "hello there", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, mentioned := groupRequest(bot, tt.text)
+			if got != tt.want || mentioned != tt.mentioned {
+				t.Fatalf("groupRequest(%q) = %q, %v, want %q, %v", tt.text, got, mentioned, tt.want, tt.mentioned)
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var requiredBinaries = []string{"yt-dlp", "ffmpeg", "ffprobe"}
+
+const (
+	// maxPollAge is how long the bot may go without a successful poll and
+	// still count as alive: a long poll lasts a minute and a failed one is
+	// retried within maxUpdatesBackoff, so only a stuck loop gets this far.
+	maxPollAge = maxUpdatesBackoff + 3*time.Minute
+
+	// telegramCheckCacheFor keeps frequent readiness probes from each
+	// costing a Telegram API call.
+	telegramCheckCacheFor = 10 * time.Second
+)
+
+func startHealthServer(bot *tgbotapi.BotAPI, port int) {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		if age := sinceLastPoll(); age > maxPollAge {
+			http.Error(w, fmt.Sprintf("no updates polled for %s", age.Round(time.Second)), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := checkTelegram(bot); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		for _, name := range requiredBinaries {
+			if _, err := exec.LookPath(name); err != nil {
+				http.Error(w, fmt.Sprintf("%s not found: %v", name, err), http.StatusServiceUnavailable)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+
+	addr := fmt.Sprintf(":%d", port)
+	log.Printf("Health server listening on %s", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Println("Health server stopped:", err)
+	}
+}
+
+var telegramCheck struct {
+	sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// checkTelegram asks Telegram who the bot is, reusing the answer for
+// telegramCheckCacheFor.
+func checkTelegram(bot *tgbotapi.BotAPI) error {
+	telegramCheck.Lock()
+	defer telegramCheck.Unlock()
+	if time.Since(telegramCheck.checked) < telegramCheckCacheFor {
+		return telegramCheck.err
+	}
+
+	telegramCheck.err = nil
+	if _, err := bot.GetMe(); err != nil {
+		telegramCheck.err = fmt.Errorf("telegram unreachable: %v", err)
+	}
+	telegramCheck.checked = time.Now()
+	return telegramCheck.err
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type commandHelp struct {
+	name      string
+	args      string
+	example   string
+	adminOnly bool
+	enabled   func() bool // nil means always available
+}
+
+// description is the catalog's one-line summary of the command in lang.
+func (c commandHelp) description(lang string) string {
+	return tr(lang, "help."+c.name)
+}
+
+const exampleURL = "https://youtu.be/dQw4w9WgXcQ"
+
+var commandHelps = []commandHelp{
+	{name: "help"},
+	{name: "sites"},
+	{name: "settings"},
+	{name: "quality", args: "[kbps|auto]", example: "/quality 192"},
+	{name: "audio", args: "[rate] [mono|stereo]", example: "/audio 44100 mono"},
+	{name: "zip", args: "[on|off]"},
+	{name: "autoplaylist", args: "[on|off]"},
+	{name: "trimsilence", args: "[on|off]"},
+	{name: "fade", args: "[on|off]"},
+	{name: "aac", args: "[on|off|0.1-2]", example: "/aac 1.2"},
+	{name: "speed", args: "[0.25-4|off]", example: "/speed 1.25"},
+	{name: "setlang", args: "[lang]", example: "/setlang de"},
+	{name: "lang", args: "[code|auto]", example: "/lang de"},
+	{name: "cancel", args: "[number]"},
+	{name: "queue", args: "[remove <n>]"},
+	{name: "retry"},
+	{name: "last"},
+	{name: "history", args: "[clear]"},
+	{name: "mystats"},
+	{name: "chapters", args: "<url> [n]", example: "/chapters " + exampleURL + " 2"},
+	{name: "formats", args: "<url>", example: "/formats " + exampleURL},
+	{name: "formatid", args: "<url> <id>", example: "/formatid " + exampleURL + " 251"},
+	{name: "playlist", args: "<url> [tracks]", example: "/playlist https://www.youtube.com/playlist?list=... 1-3,7"},
+	{name: "audiobook", args: "<playlist url>"},
+	{name: "voice", args: "<url>", example: "/voice " + exampleURL},
+	{name: "thumb", args: "<url>", example: "/thumb " + exampleURL},
+	{name: "subs", args: "<url> [lang]", example: "/subs " + exampleURL + " en"},
+	{name: "transcribe", args: "<url>", example: "/transcribe " + exampleURL,
+		enabled: func() bool { return conf.WhisperPath != "" }},
+	{name: "cache", args: "[stats|evict <id>]", adminOnly: true},
+}
+
+func handleStart(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	lines := []string{
+		tr(langOf(message), "start.greeting", supportedSitesText(langOf(message))),
+		"",
+	}
+	lines = append(lines, limitsText(langOf(message))...)
+	lines = append(lines, "", tr(langOf(message), "start.help_hint"))
+	replyText(bot, message, strings.Join(lines, "\n"))
+}
+
+// limitsText describes the limits in effect, as configured.
+func limitsText(lang string) []string {
+	var lines []string
+	if conf.MaxDurationMinutes > 0 {
+		lines = append(lines, tr(lang, "limits.duration", formatDuration(conf.MaxDurationMinutes*60)))
+	}
+	lines = append(lines, tr(lang, "limits.file_size", maxFileSize/1024/1024+1))
+	if conf.DailyQuota > 0 {
+		lines = append(lines, trn(lang, "limits.quota", conf.DailyQuota))
+	}
+	if !conf.AutoStart {
+		lines = append(lines, tr(lang, "limits.confirm"))
+	}
+	return lines
+}
+
+func handleHelp(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	admin := message.From != nil && isAdmin(message.From.ID)
+
+	limit := conf.MaxPlaylistItems
+	if limit <= 0 {
+		limit = defaultMaxPlaylistItems
+	}
+	lines := []string{
+		tr(langOf(message), "help.intro", supportedSitesText(langOf(message))),
+		exampleURL,
+		"",
+		tr(langOf(message), "help.keywords"),
+		trn(langOf(message), "help.keyword_playlist", limit),
+		tr(langOf(message), "help.keyword_merge"),
+		tr(langOf(message), "help.keyword_zip"),
+		tr(langOf(message), "help.keyword_force"),
+		"",
+		tr(langOf(message), "help.commands"),
+	}
+	for _, c := range commandHelps {
+		if (c.adminOnly && !admin) || (c.enabled != nil && !c.enabled()) {
+			continue
+		}
+		line := "/" + c.name
+		if c.args != "" {
+			line += " " + c.args
+		}
+		line += " — " + c.description(langOf(message))
+		if c.example != "" {
+			line += "\n    " + tr(langOf(message), "help.example", c.example)
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, "", tr(langOf(message), "help.limits"))
+	lines = append(lines, limitsText(langOf(message))...)
+
+	replyText(bot, message, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const maxHistoryEntries = 10
+
+type historyEntry struct {
+	VideoID  string    `json:"video-id"`
+	Title    string    `json:"title"`
+	Uploader string    `json:"uploader,omitempty"`
+	URL      string    `json:"url"`
+	Bitrate  int       `json:"bitrate"`
+	Note     string    `json:"note,omitempty"`
+	FileIDs  []string  `json:"file-ids"`
+	At       time.Time `json:"at"`
+}
+
+func (s *prefsStore) history(userID int64) []historyEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]historyEntry(nil), s.data.History[userID]...)
+}
+
+// addHistory puts entry first in the user's history, replacing an older entry
+// of the same video, and keeps the newest maxHistoryEntries.
+func (s *prefsStore) addHistory(userID int64, entry historyEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entries := []historyEntry{entry}
+	for _, old := range s.data.History[userID] {
+		if old.VideoID != entry.VideoID && len(entries) < maxHistoryEntries {
+			entries = append(entries, old)
+		}
+	}
+	s.data.History[userID] = entries
+	return s.save()
+}
+
+func (s *prefsStore) clearHistory(userID int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data.History, userID)
+	return s.save()
+}
+
+// recordHistory makes a delivered download the chat's /last and adds it to
+// the requester's history, using the file_ids the upload cache collected
+// while it was sent.
+func recordHistory(message *tgbotapi.Message, cacheKey string, meta trackMeta) {
+	cached, ok := cachedUploadFor(cacheKey)
+	if !ok {
+		return
+	}
+
+	videoID, _, _ := strings.Cut(cacheKey, "|")
+	entry := historyEntry{
+		VideoID:  videoID,
+		Title:    meta.Title,
+		Uploader: meta.Uploader,
+		URL:      meta.URL,
+		Bitrate:  meta.Bitrate,
+		Note:     cached.Note,
+		FileIDs:  cached.FileIDs,
+		At:       time.Now(),
+	}
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.Last = &entry
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+	}
+	if message.From == nil {
+		return
+	}
+	if err := prefs.addHistory(message.From.ID, entry); err != nil {
+		log.Println("Error saving history:", err)
+	}
+}
+
+func handleLast(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	last := prefs.get(message.Chat.ID).Last
+	if last == nil {
+		replyText(bot, message, tr(langOf(message), "last.none"))
+		return
+	}
+
+	meta := trackMeta{Title: last.Title, Uploader: last.Uploader, URL: last.URL, Bitrate: last.Bitrate, ReplyTo: replyTarget(message), Lang: langOf(message)}
+	_, err := sendCachedUpload(bot, message.Chat.ID, cachedUpload{FileIDs: last.FileIDs, Note: last.Note}, meta)
+	if err != nil {
+		log.Println("Error resending last download:", err)
+		replyText(bot, message, tr(langOf(message), "last.gone"))
+	}
+}
+
+func handleHistory(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	if message.From == nil {
+		return
+	}
+	userID := message.From.ID
+
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+	case "clear":
+		if err := prefs.clearHistory(userID); err != nil {
+			log.Println("Error saving prefs:", err)
+			sendText(bot, message.Chat.ID, tr(langOf(message), "history.clear_failed"))
+			return
+		}
+		sendText(bot, message.Chat.ID, tr(langOf(message), "history.cleared"))
+		return
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "history.usage"))
+		return
+	}
+
+	entries := prefs.history(userID)
+	if len(entries) == 0 {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "history.empty"))
+		return
+	}
+
+	lines := []string{tr(langOf(message), "history.header"), ""}
+	var rows [][]tgbotapi.InlineKeyboardButton
+	var row []tgbotapi.InlineKeyboardButton
+	for i, entry := range entries {
+		title := entry.Title
+		if title == "" {
+			title = entry.URL
+		}
+		lines = append(lines, fmt.Sprintf("%d. %s (%s)", i+1, title, entry.At.Format("2006-01-02")))
+
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(strconv.Itoa(i+1), fmt.Sprintf("history:%d:%s", userID, entry.VideoID)))
+		if len(row) == 5 {
+			rows = append(rows, row)
+			row = nil
+		}
+	}
+	if len(row) > 0 {
+		rows = append(rows, row)
+	}
+	lines = append(lines, "", tr(langOf(message), "history.hint"))
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n"))
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := sendMessage(bot, msg); err != nil {
+		log.Println("Error sending history:", err)
+	}
+}
+
+func handleHistoryCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, arg string) {
+	owner, videoID, _ := strings.Cut(arg, ":")
+	userID, _ := strconv.ParseInt(owner, 10, 64)
+	if query.From == nil || query.From.ID != userID || query.Message == nil {
+		answerCallback(bot, query, tr(callbackLanguage(query), "history.not_yours"))
+		return
+	}
+
+	var entry *historyEntry
+	for _, e := range prefs.history(userID) {
+		if e.VideoID == videoID {
+			entry = &e
+			break
+		}
+	}
+	if entry == nil {
+		answerCallback(bot, query, tr(callbackLanguage(query), "history.gone"))
+		return
+	}
+	answerCallback(bot, query, "")
+
+	meta := trackMeta{Title: entry.Title, Uploader: entry.Uploader, URL: entry.URL, Bitrate: entry.Bitrate, Lang: callbackLanguage(query)}
+	_, err := sendCachedUpload(bot, query.Message.Chat.ID, cachedUpload{FileIDs: entry.FileIDs, Note: entry.Note}, meta)
+	if err != nil {
+		log.Println("Error resending from history:", err)
+		sendText(bot, query.Message.Chat.ID, tr(callbackLanguage(query), "history.file_gone"))
+	}
+}
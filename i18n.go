@@ -0,0 +1,188 @@
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultLanguage = "en"
+
+//go:embed locales/*.json
+var localeFiles embed.FS
+
+// catalogEntry is one message, either a plain string or, for messages that
+// depend on a count, one string per plural form ("one", "few", "many",
+// "other").
+type catalogEntry struct {
+	text   string
+	plural map[string]string
+}
+
+func (e *catalogEntry) UnmarshalJSON(raw []byte) error {
+	if err := json.Unmarshal(raw, &e.text); err == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, &e.plural)
+}
+
+// catalogs maps a language code to its messages.
+var catalogs = loadCatalogs()
+
+func loadCatalogs() map[string]map[string]catalogEntry {
+	files, err := localeFiles.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Errorf("could not list message catalogs: %v", err))
+	}
+
+	catalogs := make(map[string]map[string]catalogEntry)
+	for _, file := range files {
+		raw, err := localeFiles.ReadFile(path.Join("locales", file.Name()))
+		if err != nil {
+			panic(fmt.Errorf("could not read message catalog %s: %v", file.Name(), err))
+		}
+		var catalog map[string]catalogEntry
+		if err := json.Unmarshal(raw, &catalog); err != nil {
+			panic(fmt.Errorf("could not parse message catalog %s: %v", file.Name(), err))
+		}
+		catalogs[strings.TrimSuffix(file.Name(), ".json")] = catalog
+	}
+	return catalogs
+}
+
+// userLanguages remembers the language each user's Telegram client uses, for
+// replies sent where only the chat is known.
+var userLanguages = struct {
+	sync.Mutex
+	byUser map[int64]string
+}{byUser: make(map[int64]string)}
+
+func rememberLanguage(user *tgbotapi.User) {
+	if user == nil || user.LanguageCode == "" {
+		return
+	}
+	userLanguages.Lock()
+	userLanguages.byUser[user.ID] = user.LanguageCode
+	userLanguages.Unlock()
+}
+
+// userLanguage picks the catalog to answer user in, in chatID: the chat's
+// choice from /lang, or else the user's client language, falling back to
+// English for languages without a catalog. In a group every request is
+// answered in the language of whoever sent it.
+func userLanguage(chatID int64, user *tgbotapi.User) string {
+	if lang := prefs.get(chatID).Lang; lang != "" {
+		return supportedLanguage(lang)
+	}
+	if user == nil {
+		return defaultLanguage
+	}
+	code := user.LanguageCode
+	if code == "" {
+		userLanguages.Lock()
+		code = userLanguages.byUser[user.ID]
+		userLanguages.Unlock()
+	}
+	return supportedLanguage(code)
+}
+
+// langOf is the language to answer message in.
+func langOf(message *tgbotapi.Message) string {
+	return userLanguage(message.Chat.ID, message.From)
+}
+
+func callbackLanguage(query *tgbotapi.CallbackQuery) string {
+	if query.Message == nil {
+		return userLanguage(0, query.From)
+	}
+	return userLanguage(query.Message.Chat.ID, query.From)
+}
+
+// chatLanguage is for messages that answer nobody in particular, such as
+// alerts: the chat's choice from /lang, or in a private chat its user's.
+func chatLanguage(chatID int64) string {
+	return userLanguage(chatID, &tgbotapi.User{ID: chatID})
+}
+
+// supportedLanguage maps a code like "de-AT" to a catalog, or English.
+func supportedLanguage(code string) string {
+	base, _, _ := strings.Cut(strings.ToLower(code), "-")
+	if _, ok := catalogs[base]; ok {
+		return base
+	}
+	return defaultLanguage
+}
+
+// tr returns the translation of key into lang, formatted with args as by
+// fmt.Sprintf. Keys missing from a catalog fall back to English.
+func tr(lang string, key string, args ...any) string {
+	entry, ok := lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := entry.text
+	if entry.plural != nil {
+		text = entry.plural["other"]
+	}
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// trn is tr for messages about a count: it picks the plural form lang uses
+// for n, which is also the message's first argument.
+func trn(lang string, key string, n int, args ...any) string {
+	entry, ok := lookup(lang, key)
+	if !ok {
+		return key
+	}
+	text := entry.text
+	if entry.plural != nil {
+		text, ok = entry.plural[pluralForm(lang, n)]
+		if !ok {
+			text = entry.plural["other"]
+		}
+	}
+	return fmt.Sprintf(text, append([]any{n}, args...)...)
+}
+
+func lookup(lang string, key string) (catalogEntry, bool) {
+	if entry, ok := catalogs[lang][key]; ok {
+		return entry, true
+	}
+	if entry, ok := catalogs[defaultLanguage][key]; ok {
+		return entry, true
+	}
+	log.Printf("Missing message %q", key)
+	return catalogEntry{}, false
+}
+
+// pluralForm returns the CLDR plural category of n in lang.
+func pluralForm(lang string, n int) string {
+	if n < 0 {
+		n = -n
+	}
+	switch lang {
+	case "ru", "uk", "be":
+		switch {
+		case n%10 == 1 && n%100 != 11:
+			return "one"
+		case n%10 >= 2 && n%10 <= 4 && (n%100 < 12 || n%100 > 14):
+			return "few"
+		default:
+			return "many"
+		}
+	default:
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	}
+}
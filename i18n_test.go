@@ -0,0 +1,47 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func TestUserLanguage(t *testing.T) {
+	saved := prefs
+	defer func() { prefs = saved }()
+	store, err := loadPrefs(filepath.Join(t.TempDir(), "prefs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefs = store
+
+	const group = -100
+	german := &tgbotapi.User{ID: 1, LanguageCode: "de-AT"}
+	russian := &tgbotapi.User{ID: 2, LanguageCode: "ru"}
+
+	// Two people in one group each get their own language.
+	if got := userLanguage(group, german); got != "de" {
+		t.Fatalf("German user got %q, want de", got)
+	}
+	if got := userLanguage(group, russian); got != "ru" {
+		t.Fatalf("Russian user got %q, want ru", got)
+	}
+	if got := userLanguage(group, &tgbotapi.User{ID: 3, LanguageCode: "xx"}); got != defaultLanguage {
+		t.Fatalf("unsupported language got %q, want %s", got, defaultLanguage)
+	}
+
+	// Without a language code, what the user's client sent before is used.
+	rememberLanguage(russian)
+	if got := userLanguage(group, &tgbotapi.User{ID: 2}); got != "ru" {
+		t.Fatalf("remembered language = %q, want ru", got)
+	}
+
+	// /lang in the chat overrides everyone's client language.
+	if err := prefs.update(group, func(p *chatPrefs) { p.Lang = "en" }); err != nil {
+		t.Fatal(err)
+	}
+	if got := userLanguage(group, german); got != "en" {
+		t.Fatalf("with /lang en, German user got %q, want en", got)
+	}
+}
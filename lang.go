@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// languageList lists the catalogs as "code — name" lines, each name in its
+// own language.
+func languageList() string {
+	var lines []string
+	for _, code := range sortedKeys(catalogs) {
+		lines = append(lines, fmt.Sprintf("%s — %s", code, tr(code, "lang.name")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// canChangeChatSettings reports whether user may change settings shared by
+// the whole chat: anyone in a private chat, only the chat's administrators in
+// a group.
+func canChangeChatSettings(bot *tgbotapi.BotAPI, chat *tgbotapi.Chat, user *tgbotapi.User) bool {
+	if !isGroupChat(chat) {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+	if isAdmin(user.ID) {
+		return true
+	}
+	outgoing.wait(chat.ID)
+	member, err := bot.GetChatMember(tgbotapi.GetChatMemberConfig{ChatConfigWithUser: tgbotapi.ChatConfigWithUser{ChatID: chat.ID, UserID: user.ID}})
+	if err != nil {
+		log.Println("Error getting chat member:", err)
+		return false
+	}
+	return member.IsCreator() || member.IsAdministrator()
+}
+
+func handleLang(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	arg := strings.ToLower(strings.TrimSpace(args))
+
+	if arg == "" {
+		current := tr(langOf(message), "lang.auto")
+		if lang := prefs.get(message.Chat.ID).Lang; lang != "" {
+			current = tr(lang, "lang.name")
+		}
+		sendText(bot, message.Chat.ID, tr(langOf(message), "lang.current", current, languageList()))
+		return
+	}
+
+	if arg != "auto" {
+		if _, ok := catalogs[arg]; !ok {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "lang.unsupported", arg, languageList()))
+			return
+		}
+	}
+
+	if !canChangeChatSettings(bot, message.Chat, message.From) {
+		replyText(bot, message, tr(langOf(message), "lang.group_admin_only"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		if arg == "auto" {
+			p.Lang = ""
+		} else {
+			p.Lang = arg
+		}
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if arg == "auto" {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "lang.cleared"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "lang.set"))
+	}
+}
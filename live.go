@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// yt-dlp gets this long past the clip length before it is killed, so a
+// stalled stream can't hold a download slot forever.
+const liveClipGrace = 5 * time.Minute
+
+var liveClipMinutes = []int{5, 15, 30}
+
+// errStillLive rejects live streams where only a finished video can be
+// downloaded, since recording one would never end.
+var errStillLive = errors.New("the video is a live stream")
+
+// liveClipOptions lists the clip lengths on offer, limited to
+// max-duration-minutes when one is set.
+func liveClipOptions() []int {
+	var options []int
+	for _, minutes := range liveClipMinutes {
+		if conf.MaxDurationMinutes > 0 && minutes > conf.MaxDurationMinutes {
+			continue
+		}
+		options = append(options, minutes)
+	}
+	if len(options) == 0 && conf.MaxDurationMinutes > 0 {
+		options = append(options, conf.MaxDurationMinutes)
+	}
+	return options
+}
+
+func askLiveClip(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string, info *videoInfo, opts downloadOptions) {
+	id := nextPendingID()
+	text := tr(langOf(message), "live.prompt", info.Title)
+
+	var row []tgbotapi.InlineKeyboardButton
+	for _, minutes := range liveClipOptions() {
+		row = append(row, tgbotapi.NewInlineKeyboardButtonData(tr(langOf(message), "live.minutes", minutes), fmt.Sprintf("live:%s:%d", id, minutes)))
+	}
+	row = append(row, tgbotapi.NewInlineKeyboardButtonData(tr(langOf(message), "confirm.cancel"), "live:"+id+":0"))
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(row)
+	prompt, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return
+	}
+
+	req := &pendingRequest{message: message, url: url, info: info, opts: opts, promptID: prompt.MessageID}
+	storePending(bot, id, req, text)
+}
+
+func handleLiveCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, arg string) {
+	id, value, _ := strings.Cut(arg, ":")
+	minutes, _ := strconv.Atoi(value)
+
+	req := claimPending(bot, query, id)
+	if req == nil {
+		return
+	}
+
+	text := query.Message.Text
+	if minutes > 0 {
+		text += "\n\n" + trn(langOf(req.message), "live.recording", minutes)
+	} else {
+		text += "\n\n" + tr(langOf(req.message), "confirm.cancelled")
+	}
+	edit := tgbotapi.NewEditMessageText(req.message.Chat.ID, req.promptID, text)
+	if _, err := sendMessage(bot, edit); err != nil {
+		log.Println("Error updating prompt:", err)
+	}
+	if minutes <= 0 {
+		return
+	}
+
+	info := *req.info
+	info.Duration = float64(minutes * 60)
+	opts := req.opts
+	opts.LiveClip = minutes * 60
+	processDownload(bot, req.message, req.url, &info, opts)
+}
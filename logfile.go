@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+const (
+	defaultLogMaxSizeMB  = 10
+	defaultLogMaxBackups = 3
+)
+
+// rotatingFile is a log file that is renamed to path.1 (shifting older ones
+// up to path.<maxBackups>) once it would grow past maxBytes.
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxBytes   int64
+	maxBackups int
+	file       *os.File
+	size       int64
+}
+
+func openRotatingFile(path string, maxBytes int64, maxBackups int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxBytes: maxBytes, maxBackups: maxBackups}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %v", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open log file: %v", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.size > 0 && r.size+int64(len(p)) > r.maxBytes {
+		if err := r.rotate(); err != nil {
+			// Keep logging into the oversized file rather than losing lines.
+			fmt.Fprintln(os.Stderr, "Error rotating log file:", err)
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return r.reopen(err)
+	}
+
+	os.Remove(fmt.Sprintf("%s.%d", r.path, r.maxBackups))
+	for i := r.maxBackups - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if r.maxBackups > 0 {
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return r.reopen(err)
+		}
+	} else {
+		os.Remove(r.path)
+	}
+
+	return r.open()
+}
+
+// reopen goes back to appending to the file that could not be rotated, so
+// logging carries on; it returns err, the reason rotating failed.
+func (r *rotatingFile) reopen(err error) error {
+	if openErr := r.open(); openErr != nil {
+		return fmt.Errorf("%v, and reopening failed: %v", err, openErr)
+	}
+	return err
+}
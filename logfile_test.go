@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotatingFileKeepsLoggingWhenRenameFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bot.log")
+	r, err := openRotatingFile(path, 10, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.file.Close()
+
+	// A non-empty directory where the backup should go can't be replaced.
+	if err := os.MkdirAll(filepath.Join(path+".1", "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first line\n", "second line\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) after a failed rotation: %v", line, err)
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(data), "first line\nsecond line\n"; got != want {
+		t.Fatalf("log file holds %q, want %q", got, want)
+	}
+}
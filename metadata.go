@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"log"
+	"math"
+	"os/exec"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Caption pieces are capped well below Telegram's 1024 character limit.
+const (
+	maxCaptionTitleBytes    = 400
+	maxCaptionUploaderBytes = 100
+)
+
+type videoInfo struct {
+	ID        string  `json:"id"`
+	Extractor string  `json:"extractor_key"`
+	Title     string  `json:"title"`
+	Uploader  string  `json:"uploader"`
+	Duration  float64 `json:"duration"`
+	Language  string  `json:"language"`
+	IsLive    bool    `json:"is_live"`
+	ACodec    string  `json:"acodec"`
+	ABR       float64 `json:"abr"`
+
+	Subtitles         map[string][]subtitleTrack `json:"subtitles"`
+	AutomaticCaptions map[string][]subtitleTrack `json:"automatic_captions"`
+
+	Chapters []videoChapter `json:"chapters"`
+	Formats  []videoFormat  `json:"formats"`
+
+	RequestedFormats []struct {
+		ACodec string  `json:"acodec"`
+		ABR    float64 `json:"abr"`
+	} `json:"requested_formats"`
+}
+
+type videoFormat struct {
+	FormatID       string  `json:"format_id"`
+	Ext            string  `json:"ext"`
+	ACodec         string  `json:"acodec"`
+	VCodec         string  `json:"vcodec"`
+	ABR            float64 `json:"abr"`
+	Filesize       int64   `json:"filesize"`
+	FilesizeApprox int64   `json:"filesize_approx"`
+}
+
+type subtitleTrack struct {
+	Ext string `json:"ext"`
+}
+
+type videoChapter struct {
+	Title     string  `json:"title"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+}
+
+type trackMeta struct {
+	Title    string
+	Uploader string
+	URL      string
+	Bitrate  int
+
+	// Duration is the source video's length in seconds, used when the
+	// sent file itself can't be probed.
+	Duration float64
+
+	BitrateNote string
+
+	// Part and Parts are 1-based and only set for split files.
+	Part  int
+	Parts int
+	Note  string
+
+	ReplyTo int
+	// Lang is the language captions and titles are written in.
+	Lang string
+
+	// CacheKey, when set, makes successful sends populate the file_id cache.
+	CacheKey string
+}
+
+func newTrackMeta(url string, info *videoInfo, kbps int, lang string) trackMeta {
+	meta := trackMeta{URL: url, Bitrate: kbps, Lang: lang}
+	if info != nil {
+		meta.Title = sanitizeTitle(info.Title)
+		meta.Uploader = sanitizeTitle(info.Uploader)
+		meta.Duration = info.Duration
+	}
+	return meta
+}
+
+// audioDuration returns the length in whole seconds to show in the player,
+// preferring the file itself since trimming and splitting change it.
+func (m trackMeta) audioDuration(filePath string) int {
+	duration, err := probeDuration(filePath)
+	if err != nil {
+		log.Println("Error probing duration:", err)
+		if m.Parts > 0 {
+			return 0
+		}
+		duration = m.Duration
+	}
+	return int(math.Round(duration))
+}
+
+func (m trackMeta) partLabel() string {
+	if m.Parts == 0 {
+		return ""
+	}
+	return tr(m.Lang, "caption.part", m.Part, m.Parts)
+}
+
+func (m trackMeta) audioTitle() string {
+	if m.Title == "" || m.Parts == 0 {
+		return m.Title
+	}
+	return m.Title + " — " + m.partLabel()
+}
+
+func (m trackMeta) caption() string {
+	var lines []string
+	if title := m.audioTitle(); title != "" {
+		lines = append(lines, title)
+	} else if label := m.partLabel(); label != "" {
+		lines = append(lines, label)
+	}
+	if m.Uploader != "" {
+		lines = append(lines, m.Uploader)
+	}
+	if m.URL != "" {
+		lines = append(lines, m.URL)
+	}
+	if m.Note != "" {
+		lines = append(lines, m.Note)
+	}
+	return truncateUTF16(strings.Join(lines, "\n"), maxCaptionLength)
+}
+
+// captionFor renders the caption from caption-template, or else in the
+// configured caption-style, along with the parse mode it needs. duration and
+// size are left out when zero.
+func (m trackMeta) captionFor(duration int, size int64) (string, string) {
+	if conf.CaptionStyle != "off" && captionTemplate != nil {
+		caption, err := m.templateCaption(duration, size)
+		if err == nil {
+			return caption, ""
+		}
+		log.Println("Error rendering caption-template, using the default caption:", err)
+	}
+
+	switch conf.CaptionStyle {
+	case "off":
+		return "", ""
+	case "plain":
+		return m.caption(), ""
+	default:
+		return m.richCaption(duration, size), tgbotapi.ModeHTML
+	}
+}
+
+// richCaption is a one-line summary like "🎵 Title · 12:34 · 11.2 MB ·
+// source" in HTML, followed by the uploader and the note. Telegram counts
+// only the text, not the markup, against the caption limit, so the note is
+// cut to what the rest leaves of it rather than the HTML as a whole.
+func (m trackMeta) richCaption(duration int, size int64) string {
+	// summary is the HTML, text what it shows.
+	var summary, text []string
+	add := func(html, shown string) {
+		summary = append(summary, html)
+		text = append(text, shown)
+	}
+	title := m.audioTitle()
+	if title == "" {
+		title = m.partLabel()
+	}
+	if title != "" {
+		title = truncateBytes(title, maxCaptionTitleBytes)
+		add("<b>"+html.EscapeString(title)+"</b>", title)
+	}
+	if duration > 0 {
+		add(formatDuration(duration), formatDuration(duration))
+	}
+	if size > 0 {
+		add(formatSize(size), formatSize(size))
+	}
+	if m.URL != "" {
+		source := tr(m.Lang, "caption.source")
+		add(`<a href="`+html.EscapeString(m.URL)+`">`+html.EscapeString(source)+`</a>`, source)
+	}
+
+	lines := []string{"🎵 " + strings.Join(summary, " · ")}
+	shown := "🎵 " + strings.Join(text, " · ")
+	if m.Uploader != "" {
+		uploader := truncateBytes(m.Uploader, maxCaptionUploaderBytes)
+		lines = append(lines, html.EscapeString(uploader))
+		shown += "\n" + uploader
+	}
+	if left := maxCaptionLength - utf16Len(shown) - 1; m.Note != "" && left > 0 {
+		lines = append(lines, html.EscapeString(truncateUTF16(m.Note, left)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func fetchVideoInfo(url string) (*videoInfo, error) {
+	cmd := ytDlpCommand("--dump-json", "--no-playlist", "--", url)
+
+	output, err := cmd.Output()
+	if err != nil {
+		var stderr []byte
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			stderr = exitErr.Stderr
+		}
+		result := classifyExecError(err, stderr)
+		log.Println("Error fetching video info:", result)
+		return nil, &downloadError{Kind: classifyYtDlpError(string(stderr)), Exec: result, Output: string(stderr), Err: err}
+	}
+
+	var info videoInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("could not parse video info: %v", err)
+	}
+
+	return &info, nil
+}
+
+func formatDuration(seconds int) string {
+	h := seconds / 3600
+	m := seconds % 3600 / 60
+	s := seconds % 60
+	if h > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", h, m, s)
+	}
+	return fmt.Sprintf("%d:%02d", m, s)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const oversizeChoiceTimeout = 3 * time.Minute
+
+// oversizePrompt is a question waiting for the person whose job it is.
+type oversizePrompt struct {
+	userID int64 // 0 when anyone may answer
+	choice chan bool
+}
+
+var (
+	oversizeMu     sync.Mutex
+	oversizeNextID int
+	oversizeChoice = make(map[string]oversizePrompt)
+)
+
+// oversizeAnswer returns what the job's owner chose the last time a file was
+// too large, if they were asked already.
+func (j *activeJob) oversizeAnswer() (bool, bool) {
+	if j == nil {
+		return false, false
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.shrink == nil {
+		return false, false
+	}
+	return *j.shrink, true
+}
+
+func (j *activeJob) rememberOversizeAnswer(shrink bool) {
+	if j == nil {
+		return
+	}
+	j.mu.Lock()
+	j.shrink = &shrink
+	j.mu.Unlock()
+}
+
+// chooseOversizeStrategy asks whoever sent the link whether an oversized file
+// should be split or re-encoded into a single file, once per job: the other
+// tracks of a playlist get the same answer. It returns the bitrate to
+// re-encode at and true for a single file, or false to split. If the job is
+// cancelled while waiting, the question is taken down and errCancelled
+// returned.
+func chooseOversizeStrategy(bot *tgbotapi.BotAPI, chatID int64, filePath string, size int64, meta trackMeta, opts downloadOptions) (int, bool, error) {
+	lang := meta.Lang
+	fitKbps, canShrink := requiredBitrate(filePath, meta.Bitrate)
+	if !canShrink {
+		return 0, false, nil
+	}
+	if shrink, ok := opts.Job.oversizeAnswer(); ok {
+		return fitKbps, shrink, nil
+	}
+	_, shrinkByDefault := fitBitrate(filePath, size, meta.Bitrate)
+
+	parts := int((size + maxFileSize - 1) / maxFileSize)
+
+	oversizeMu.Lock()
+	oversizeNextID++
+	id := strconv.Itoa(oversizeNextID)
+	choice := make(chan bool, 1)
+	prompt := oversizePrompt{choice: choice}
+	if opts.Job != nil {
+		prompt.userID = opts.Job.userID
+	}
+	oversizeChoice[id] = prompt
+	oversizeMu.Unlock()
+
+	defer func() {
+		oversizeMu.Lock()
+		delete(oversizeChoice, id)
+		oversizeMu.Unlock()
+	}()
+
+	text := tr(lang, "oversize.prompt", formatSize(size))
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyToMessageID = meta.ReplyTo
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(trn(lang, "oversize.split", parts), "split:"+id),
+			tgbotapi.NewInlineKeyboardButtonData(tr(lang, "oversize.shrink", fitKbps), "shrink:"+id),
+		),
+	)
+	sent, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return fitKbps, shrinkByDefault, nil
+	}
+
+	// Waiting for an answer is no work, so others may download meanwhile.
+	resume := downloads.pause(opts.Slot)
+	shrink := shrinkByDefault
+	select {
+	case shrink = <-choice:
+		opts.Job.rememberOversizeAnswer(shrink)
+	case <-time.After(oversizeChoiceTimeout):
+		log.Println("No answer to oversize prompt, using default")
+	case <-opts.Job.stop():
+	}
+	if !resume(opts.Job.stop()) || opts.Job.isCancelled() {
+		deletion := tgbotapi.NewDeleteMessage(chatID, sent.MessageID)
+		throttle(deletion)
+		if _, err := bot.Request(deletion); err != nil {
+			log.Println("Error deleting prompt:", err)
+		}
+		return 0, false, errCancelled
+	}
+
+	result := trn(lang, "oversize.splitting", parts)
+	if shrink {
+		result = tr(lang, "oversize.shrinking", fitKbps)
+	}
+	edit := tgbotapi.NewEditMessageText(chatID, sent.MessageID, text+"\n\n"+result)
+	if _, err := sendMessage(bot, edit); err != nil {
+		log.Println("Error updating prompt:", err)
+	}
+
+	return fitKbps, shrink, nil
+}
+
+func handleOversizeCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, id string, shrink bool) {
+	oversizeMu.Lock()
+	prompt, ok := oversizeChoice[id]
+	oversizeMu.Unlock()
+
+	if !ok {
+		answerCallback(bot, query, tr(callbackLanguage(query), "oversize.already_chosen"))
+		return
+	}
+	if prompt.userID != 0 && (query.From == nil || (query.From.ID != prompt.userID && !isAdmin(query.From.ID))) {
+		answerCallback(bot, query, tr(callbackLanguage(query), "confirm.not_yours"))
+		return
+	}
+
+	select {
+	case prompt.choice <- shrink:
+		answerCallback(bot, query, "")
+	default:
+		answerCallback(bot, query, tr(callbackLanguage(query), "oversize.already_chosen"))
+	}
+}
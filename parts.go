@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const sentPartsName = "parts.json"
+
+// sentParts records which parts of a split upload reached the chat. It is
+// kept in the job directory, so sending the job again, after a restart or
+// through /retry, skips the parts that were already delivered.
+type sentParts struct {
+	mu   sync.Mutex
+	path string
+
+	Title string `json:"title"`
+	Parts int    `json:"parts"`
+	Sent  []int  `json:"sent"`
+}
+
+func loadSentParts(dir string, meta trackMeta) *sentParts {
+	s := &sentParts{path: filepath.Join(dir, sentPartsName), Title: meta.Title, Parts: meta.Parts}
+
+	raw, err := os.ReadFile(s.path)
+	if err != nil {
+		return s
+	}
+	var saved sentParts
+	if err := json.Unmarshal(raw, &saved); err != nil {
+		log.Println("Ignoring unreadable record of sent parts:", err)
+		return s
+	}
+	// A different split produces different parts, which all still need sending.
+	if saved.Title == s.Title && saved.Parts == s.Parts {
+		s.Sent = saved.Sent
+	}
+	return s
+}
+
+func (s *sentParts) has(part int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Contains(s.Sent, part)
+}
+
+func (s *sentParts) mark(part int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if slices.Contains(s.Sent, part) {
+		return
+	}
+	s.Sent = append(s.Sent, part)
+
+	raw, err := json.Marshal(s)
+	if err == nil {
+		err = os.WriteFile(s.path, raw, 0644)
+	}
+	if err != nil {
+		log.Println("Could not record sent part, a resend may repeat it:", err)
+	}
+}
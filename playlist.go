@@ -0,0 +1,604 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const defaultMaxPlaylistItems = 50
+
+type playlistEntry struct {
+	ID       string  `json:"id"`
+	Title    string  `json:"title"`
+	URL      string  `json:"url"`
+	Duration float64 `json:"duration"`
+}
+
+type playlistInfo struct {
+	Title    string          `json:"title"`
+	Uploader string          `json:"uploader"`
+	Entries  []playlistEntry `json:"entries"`
+}
+
+var youTubeHosts = map[string]bool{
+	"youtube.com": true, "www.youtube.com": true, "m.youtube.com": true, "music.youtube.com": true, "youtu.be": true,
+}
+
+// isPlaylistURL accepts YouTube links that name a playlist in their list
+// parameter, whether a playlist page or a video played from one.
+func isPlaylistURL(url string) bool {
+	parsed, err := neturl.Parse(url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	if !youTubeHosts[strings.ToLower(parsed.Hostname())] {
+		return false
+	}
+	return parsed.Query().Get("list") != ""
+}
+
+func (e playlistEntry) watchURL() string {
+	if strings.HasPrefix(e.URL, "http") {
+		return e.URL
+	}
+	return "https://www.youtube.com/watch?v=" + e.ID
+}
+
+// probeEntry looks an entry up before it is downloaded. Flat playlist
+// listings don't say whether a video is live, and a live one would keep
+// yt-dlp recording forever.
+func probeEntry(entry playlistEntry, playlist *playlistInfo) (*videoInfo, error) {
+	info, err := fetchVideoInfo(entry.watchURL())
+	if err != nil {
+		return nil, err
+	}
+	if info.IsLive {
+		return nil, errStillLive
+	}
+	if info.Title == "" {
+		info.Title = entry.Title
+	}
+	if info.Uploader == "" {
+		info.Uploader = playlist.Uploader
+	}
+	return info, nil
+}
+
+// checkEntry probes an entry and holds it to the limits a single download
+// has to pass. For an entry that has to be skipped it tells the user why and
+// returns nil, with a short reason for the summary.
+func checkEntry(bot *tgbotapi.BotAPI, chatID int64, lang string, entry playlistEntry, playlist *playlistInfo) (*videoInfo, string) {
+	info, err := probeEntry(entry, playlist)
+	if err != nil {
+		log.Printf("Skipping playlist entry %s: %v", entry.ID, err)
+		sendText(bot, chatID, tr(lang, "track.skipping", entry.Title, userErrorMessage(lang, err)))
+		return nil, failureReason(lang, err)
+	}
+	if reason := checkDurationLimit(lang, info); reason != "" {
+		log.Printf("Skipping playlist entry %s: over max-duration-minutes", entry.ID)
+		sendText(bot, chatID, tr(lang, "track.skipping", entry.Title, reason))
+		return nil, tr(lang, "reason.too_long")
+	}
+	return info, ""
+}
+
+func fetchPlaylistInfo(url string) (*playlistInfo, error) {
+	cmd := ytDlpCommand("--flat-playlist", "--dump-single-json", "--yes-playlist", "--", url)
+
+	output, err := cmd.Output()
+	if err != nil {
+		log.Println("Error fetching playlist info:", err)
+		return nil, fmt.Errorf("could not fetch playlist info: %v", err)
+	}
+
+	var info playlistInfo
+	if err := json.Unmarshal(output, &info); err != nil {
+		return nil, fmt.Errorf("could not parse playlist info: %v", err)
+	}
+
+	return &info, nil
+}
+
+func processPlaylist(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string, opts downloadOptions) {
+	chatID := message.Chat.ID
+	lang := langOf(message)
+
+	playlist, err := fetchPlaylistInfo(url)
+	if err != nil {
+		replyText(bot, message, tr(lang, "playlist.read_failed", err))
+		return
+	}
+	if len(opts.Tracks) > 0 {
+		var entries []playlistEntry
+		for _, entry := range selectTracks(playlist.Entries, opts.Tracks) {
+			entries = append(entries, entry.playlistEntry)
+		}
+		playlist.Entries = entries
+	}
+	if len(playlist.Entries) == 0 {
+		replyText(bot, message, tr(lang, "playlist.empty"))
+		return
+	}
+
+	limit := conf.MaxPlaylistItems
+	if limit <= 0 {
+		limit = defaultMaxPlaylistItems
+	}
+	if len(playlist.Entries) > limit {
+		replyText(bot, message, trn(lang, "playlist.truncated", len(playlist.Entries), limit))
+		playlist.Entries = playlist.Entries[:limit]
+	}
+
+	reason, refund := takeQuotaFor(message, len(playlist.Entries))
+	if reason != "" {
+		replyText(bot, message, reason)
+		return
+	}
+
+	job := newQueuedJob(message, url, &videoInfo{Title: playlist.Title})
+	reportQueuePosition(bot, message, job, downloads.enqueue(job))
+	if !downloads.wait(job) {
+		refund()
+		return
+	}
+	defer downloads.release(job)
+
+	active := startActiveJob(message)
+	defer active.finish()
+	defer replyWithCancel(bot, message, trn(lang, "playlist.downloading", len(playlist.Entries), playlist.Title), active)()
+
+	entryOpts := opts
+	entryOpts.Job = active
+	entryOpts.Slot = job
+	entryOpts.Playlist = false
+	entryOpts.Merge = false
+	if entryOpts.Files == nil {
+		entryOpts.Files = newFileBudget()
+	}
+
+	if opts.Merge {
+		mergePlaylist(bot, message, url, playlist, entryOpts)
+		return
+	}
+
+	summary := playlistSummary{started: time.Now(), total: len(playlist.Entries)}
+
+	// With zip delivery the tracks are held back in a directory of their own
+	// until the whole playlist is downloaded, then sent as one archive if it
+	// fits.
+	var held []playlistTrack
+	var heldDir string
+	var heldSize int64
+	if opts.Zip {
+		heldDir, err = newJobDir(chatID)
+		if err != nil {
+			log.Println("Error creating job directory:", err)
+			sendText(bot, chatID, tr(lang, "download.prepare_failed", err))
+			return
+		}
+		defer removeJobDir(heldDir)
+	}
+	entryOpts.Zip = false
+	sendTrack := func(track playlistTrack) {
+		if err := checkAndSendFile(track.path, chatID, bot, track.meta, entryOpts); errors.Is(err, errCancelled) {
+			// Reported once the loop stops.
+		} else if errors.Is(err, errTooManyFiles) {
+			sendText(bot, chatID, tr(lang, "track.skipping", track.title, userErrorMessage(lang, err)))
+			summary.fail(track.title, failureReason(lang, err))
+		} else if err != nil {
+			log.Printf("Error sending playlist entry %s: %v", track.title, err)
+			sendText(bot, chatID, tr(lang, "playlist.track_send_failed", track.title, err))
+			summary.fail(track.title, tr(lang, "reason.upload_failed"))
+		} else {
+			summary.sent++
+			summary.size += track.size
+		}
+	}
+
+	for _, entry := range playlist.Entries {
+		if active.isCancelled() {
+			break
+		}
+		entryInfo, reason := checkEntry(bot, chatID, lang, entry, playlist)
+		if entryInfo == nil {
+			summary.fail(entry.Title, reason)
+			continue
+		}
+		kbps, bitrateNote := selectBitrate(chatID, lang, entryInfo)
+		if reason := checkDiskSpace(bot, lang, entryInfo, kbps); reason != "" {
+			sendText(bot, chatID, reason)
+			break
+		}
+
+		dir, err := newJobDir(chatID)
+		if err != nil {
+			log.Println("Error creating job directory:", err)
+			sendText(bot, chatID, tr(lang, "download.prepare_failed", err))
+			return
+		}
+		if !diskUsage.reserve(dir, requiredDiskSpace(entryInfo, kbps)) {
+			log.Printf("Stopping playlist %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+			sendText(bot, chatID, tr(lang, "download.no_disk_space"))
+			removeJobDir(dir)
+			break
+		}
+		mp3FilePath, err := downloadMp3(entry.watchURL(), dir, kbps, entryOpts)
+		if err == nil {
+			mp3FilePath, err = postProcess(chatID, mp3FilePath, kbps, entryOpts, nil)
+		}
+		if errors.Is(err, errCancelled) {
+			removeJobDir(dir)
+			break
+		}
+		if errors.Is(err, errTooManyFiles) {
+			log.Printf("Stopping playlist %s after %d files", url, maxOutputFiles())
+			sendText(bot, chatID, userErrorMessage(lang, err))
+			removeJobDir(dir)
+			break
+		}
+		if err != nil {
+			log.Printf("Error downloading playlist entry %s: %v", entry.ID, err)
+			sendText(bot, chatID, tr(lang, "track.skipping", entry.Title, userErrorMessage(lang, err)))
+			summary.fail(entry.Title, failureReason(lang, err))
+			removeJobDir(dir)
+			continue
+		}
+
+		var size int64
+		if fileInfo, err := os.Stat(mp3FilePath); err == nil {
+			size = fileInfo.Size()
+		}
+
+		meta := newTrackMeta(entry.watchURL(), entryInfo, kbps, lang)
+		meta.BitrateNote = bitrateNote
+		meta.ReplyTo = replyTarget(message)
+		track := playlistTrack{path: mp3FilePath, title: entry.Title, size: size, meta: meta}
+		if opts.Zip {
+			// Each track gets its own folder, as split parts keep a record
+			// of what was sent next to them.
+			trackDir := filepath.Join(heldDir, fmt.Sprintf("%03d", len(held)+1))
+			track.path = filepath.Join(trackDir, filepath.Base(mp3FilePath))
+			err := os.Mkdir(trackDir, 0755)
+			if err == nil {
+				err = os.Rename(mp3FilePath, track.path)
+			}
+			removeJobDir(dir)
+			if err != nil {
+				log.Printf("Error holding back playlist entry %s: %v", entry.ID, err)
+				sendText(bot, chatID, tr(lang, "playlist.track_send_failed", entry.Title, err))
+				summary.fail(entry.Title, tr(lang, "reason.error"))
+				continue
+			}
+			held = append(held, track)
+			heldSize += size
+			if !diskUsage.reserve(heldDir, heldSize) {
+				log.Printf("Stopping playlist %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+				sendText(bot, chatID, tr(lang, "download.no_disk_space"))
+				break
+			}
+			continue
+		}
+		sendTrack(track)
+		removeJobDir(dir)
+	}
+
+	if active.isCancelled() {
+		log.Printf("Playlist %s was cancelled after %d of %d tracks", url, summary.sent, summary.total)
+		sendText(bot, chatID, tr(lang, "download.cancelled"))
+		held = nil
+	}
+
+	if len(held) > 0 {
+		files := make([]string, len(held))
+		names := make([]string, len(held))
+		var size int64
+		for i, track := range held {
+			files[i] = track.path
+			names[i] = sanitizeFilename(fmt.Sprintf("%02d - %s", i+1, track.title)) + filepath.Ext(track.path)
+			size += track.size
+		}
+
+		meta := trackMeta{Title: playlist.Title, URL: url, ReplyTo: replyTarget(message), Lang: lang}
+		sent, err := sendZip(bot, chatID, files, names, meta)
+		if err != nil {
+			log.Println("Error sending playlist zip, sending tracks individually:", err)
+		}
+		if sent {
+			summary.sent += len(held)
+			summary.size += size
+		} else {
+			if err == nil {
+				sendText(bot, chatID, tr(lang, "zip.too_large_tracks"))
+			}
+			for _, track := range held {
+				sendTrack(track)
+			}
+		}
+	}
+
+	replyText(bot, message, summary.text(lang))
+}
+
+type playlistTrack struct {
+	path  string
+	title string
+	size  int64
+	meta  trackMeta
+}
+
+type playlistFailure struct {
+	title  string
+	reason string
+}
+
+type playlistSummary struct {
+	started  time.Time
+	total    int
+	sent     int
+	size     int64
+	failures []playlistFailure
+}
+
+func (s *playlistSummary) fail(title string, reason string) {
+	s.failures = append(s.failures, playlistFailure{title: title, reason: reason})
+}
+
+func (s *playlistSummary) text(lang string) string {
+	var sb strings.Builder
+	sb.WriteString(trn(lang, "playlist.summary", s.total, s.sent))
+	if len(s.failures) > 0 {
+		counts := make(map[string]int)
+		var reasons []string
+		for _, failure := range s.failures {
+			if counts[failure.reason] == 0 {
+				reasons = append(reasons, failure.reason)
+			}
+			counts[failure.reason]++
+		}
+		var parts []string
+		for _, reason := range reasons {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[reason], reason))
+		}
+		sb.WriteString(tr(lang, "playlist.summary_failed", len(s.failures), strings.Join(parts, ", ")))
+	}
+	sb.WriteString(tr(lang, "playlist.summary_size", formatSize(s.size), formatDuration(int(time.Since(s.started).Seconds()))))
+
+	if len(s.failures) > 0 {
+		sb.WriteString("\n\n" + tr(lang, "playlist.not_sent") + "\n")
+		for _, failure := range s.failures {
+			line := fmt.Sprintf("• %s (%s)\n", failure.title, failure.reason)
+			if sb.Len()+len(line) > maxChapterListLength {
+				sb.WriteString("…\n")
+				break
+			}
+			sb.WriteString(line)
+		}
+	}
+	return strings.TrimRight(sb.String(), "\n")
+}
+
+func mergePlaylist(bot *tgbotapi.BotAPI, message *tgbotapi.Message, url string, playlist *playlistInfo, opts downloadOptions) {
+	chatID := message.Chat.ID
+	lang := langOf(message)
+	var total float64
+	for _, entry := range playlist.Entries {
+		total += entry.Duration
+	}
+	kbps, bitrateNote := selectBitrate(chatID, lang, &videoInfo{Duration: total})
+	if reason := checkDiskSpace(bot, lang, &videoInfo{Duration: total}, kbps); reason != "" {
+		sendText(bot, chatID, reason)
+		return
+	}
+
+	dir, err := newJobDir(chatID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		sendText(bot, chatID, tr(lang, "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+	// The tracks and the file they are merged into are on disk together.
+	if !diskUsage.reserve(dir, requiredDiskSpace(&videoInfo{Duration: total}, kbps)+estimateSize(total, kbps)) {
+		log.Printf("Rejecting merge of %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+		sendText(bot, chatID, tr(lang, "download.no_disk_space"))
+		return
+	}
+
+	var tracks []string
+	var titles []string
+
+	cancelled := func() bool {
+		if !opts.Job.isCancelled() {
+			return false
+		}
+		log.Printf("Merge of %s was cancelled", url)
+		sendText(bot, chatID, tr(lang, "download.cancelled"))
+		return true
+	}
+
+	for i, entry := range playlist.Entries {
+		if cancelled() {
+			return
+		}
+		if info, _ := checkEntry(bot, chatID, lang, entry, playlist); info == nil {
+			continue
+		}
+		mp3FilePath, err := downloadMp3(entry.watchURL(), filepath.Join(dir, fmt.Sprintf("track_%03d", i)), kbps, opts)
+		if err == nil {
+			err = processAudio(chatID, mp3FilePath, kbps, opts)
+		}
+		if errors.Is(err, errCancelled) {
+			cancelled()
+			return
+		}
+		if errors.Is(err, errTooManyFiles) {
+			log.Printf("Stopping playlist %s after %d files", url, maxOutputFiles())
+			sendText(bot, chatID, userErrorMessage(lang, err))
+			return
+		}
+		if err != nil {
+			log.Printf("Error downloading playlist entry %s: %v", entry.ID, err)
+			sendText(bot, chatID, tr(lang, "track.skipping", entry.Title, userErrorMessage(lang, err)))
+			continue
+		}
+		tracks = append(tracks, mp3FilePath)
+		titles = append(titles, entry.Title)
+	}
+
+	if len(tracks) == 0 {
+		sendText(bot, chatID, tr(lang, "playlist.none_downloaded"))
+		return
+	}
+
+	mergedPath := filepath.Join(dir, sanitizeFilename(playlist.Title)+".mp3")
+	offsets, err := concatTracks(tracks, mergedPath, kbps, opts.Job)
+	if cancelled() {
+		return
+	}
+	if err != nil {
+		log.Println("Error merging playlist:", err)
+		sendText(bot, chatID, tr(lang, "playlist.merge_failed", err))
+		return
+	}
+	// The tracks were processed one by one; only the format is left.
+	mergedPath, err = deliveryFormat(chatID, mergedPath, kbps, opts)
+	if cancelled() {
+		return
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s\n", playlist.Title)
+	for i, offset := range offsets {
+		line := fmt.Sprintf("%s %s\n", formatDuration(int(offset)), titles[i])
+		if sb.Len()+len(line) > maxChapterListLength {
+			sb.WriteString("…\n")
+			break
+		}
+		sb.WriteString(line)
+	}
+	sendText(bot, chatID, sb.String())
+
+	meta := trackMeta{Title: playlist.Title, Uploader: playlist.Uploader, URL: url, Bitrate: kbps, BitrateNote: bitrateNote, ReplyTo: replyTarget(message), Lang: lang}
+	opts.Commit = true
+	if err := checkAndSendFile(mergedPath, chatID, bot, meta, opts); errors.Is(err, errCancelled) {
+		cancelled()
+	} else if err != nil {
+		log.Println("Error sending merged playlist:", err)
+		sendText(bot, chatID, tr(lang, "download.send_failed", err))
+	}
+}
+
+// concatTracks joins the tracks in order and returns the start offset of each
+// one in seconds.
+func concatTracks(tracks []string, outputPath string, kbps int, job *activeJob) ([]float64, error) {
+	offsets := make([]float64, len(tracks))
+	var position float64
+	sameFormat := true
+	var firstFormat string
+	for i, track := range tracks {
+		offsets[i] = position
+		duration, err := probeDuration(track)
+		if err != nil {
+			return nil, err
+		}
+		position += duration
+
+		format, err := probeStreamFormat(track)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			firstFormat = format
+		} else if format != firstFormat {
+			sameFormat = false
+		}
+	}
+
+	listPath := outputPath + ".txt"
+	defer removeTempFile(listPath)
+
+	var list strings.Builder
+	for _, track := range tracks {
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(track, "'", `'\''`))
+	}
+	if err := os.WriteFile(listPath, []byte(list.String()), 0644); err != nil {
+		return nil, fmt.Errorf("could not write concat list: %v", err)
+	}
+
+	args := []string{"-f", "concat", "-safe", "0", "-i", listPath}
+	if sameFormat {
+		args = append(args, "-c", "copy", outputPath)
+	} else {
+		log.Println("Playlist tracks have different formats, re-encoding while merging")
+		args = append(args, "-c:a", "libmp3lame", "-b:a", fmt.Sprintf("%dk", kbps), outputPath)
+	}
+
+	output, err := job.run(exec.Command("ffmpeg", args...))
+	if err != nil {
+		log.Printf("Error merging with ffmpeg: %s\n%s", err, string(output))
+		return nil, err
+	}
+
+	return offsets, nil
+}
+
+func probeStreamFormat(filePath string) (string, error) {
+	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0", "-show_entries", "stream=codec_name,sample_rate,channels", "-of", "csv=p=0", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("could not probe file: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func autoPlaylistFor(chatID int64) bool {
+	if enabled := prefs.get(chatID).AutoPlaylist; enabled != nil {
+		return *enabled
+	}
+	return conf.AutoPlaylist
+}
+
+func handleAutoPlaylistSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if autoPlaylistFor(message.Chat.ID) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "autoplaylist.current_on"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "autoplaylist.current_off"))
+		}
+		return
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "autoplaylist.usage"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.AutoPlaylist = &enabled
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if enabled {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "autoplaylist.on"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "autoplaylist.off"))
+	}
+}
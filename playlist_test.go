@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestIsPlaylistURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want bool
+	}{
+		{"https://www.youtube.com/playlist?list=PL1234", true},
+		{"https://youtube.com/watch?v=abc&list=PL1234", true},
+		{"http://m.youtube.com/watch?v=abc&list=PL1234", true},
+		{"https://music.youtube.com/playlist?list=OLAK5uy", true},
+		{"https://youtu.be/abc?list=PL1234", true},
+		{"https://www.youtube.com/watch?v=abc", false},
+		{"https://www.youtube.com/playlist", false},
+		{"https://www.youtube.com/playlist?list=", false},
+		{"https://example.com/?list=PL1234", false},
+		{"https://youtube.com.example.com/playlist?list=PL1234", false},
+		{"shopping list=eggs", false},
+		{"--exec=touch /tmp/x;list=1", false},
+		{"youtube.com/playlist?list=PL1234", false},
+	}
+
+	for _, tt := range tests {
+		if got := isPlaylistURL(tt.url); got != tt.want {
+			t.Errorf("isPlaylistURL(%q) = %t, want %t", tt.url, got, tt.want)
+		}
+	}
+}
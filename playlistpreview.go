@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// maxPreviewText keeps the track list inside Telegram's 4096 character limit
+// with room for the summary.
+const maxPreviewText = 3500
+
+// handlePlaylistPreview lists a playlist's tracks before anything is
+// downloaded, optionally narrowed down to a selection like "1-3,7", and asks
+// for confirmation.
+func handlePlaylistPreview(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || len(fields) > 2 || !(isPlaylistURL(fields[0]) || isValidYouTubeURL(fields[0])) {
+		replyText(bot, message, tr(langOf(message), "preview.usage"))
+		return
+	}
+	url := fields[0]
+
+	var tracks []int
+	if len(fields) == 2 {
+		var problem string
+		if tracks, problem = parseTrackSelection(langOf(message), fields[1]); problem != "" {
+			replyText(bot, message, problem)
+			return
+		}
+	}
+
+	playlist, err := fetchPlaylistInfo(url)
+	if err != nil {
+		replyText(bot, message, tr(langOf(message), "playlist.read_failed", err))
+		return
+	}
+	entries := selectTracks(playlist.Entries, tracks)
+	if len(entries) == 0 {
+		replyText(bot, message, tr(langOf(message), "preview.no_such_tracks"))
+		return
+	}
+
+	limit := conf.MaxPlaylistItems
+	if limit <= 0 {
+		limit = defaultMaxPlaylistItems
+	}
+
+	var lines []string
+	var total float64
+	var size int64
+	listed := 0
+	length := 0
+	for i, entry := range entries {
+		if i >= limit {
+			break
+		}
+		total += entry.Duration
+		kbps, _ := selectBitrate(message.Chat.ID, langOf(message), &videoInfo{Duration: entry.Duration})
+		size += estimateSize(entry.Duration, kbps)
+
+		line := fmt.Sprintf("%d. %s", entry.index, truncateBytes(entry.Title, 80))
+		if entry.Duration > 0 {
+			line += " (" + formatDuration(int(entry.Duration)) + ")"
+		}
+		if length+len(line) < maxPreviewText {
+			lines = append(lines, line)
+			length += len(line) + 1
+			listed++
+		}
+	}
+	count := min(len(entries), limit)
+	if listed < count {
+		lines = append(lines, tr(langOf(message), "preview.more", count-listed))
+	}
+
+	summary := playlist.Title + ": " + trn(langOf(message), "preview.summary", count, formatDuration(int(total)), formatSize(size))
+	if len(entries) > limit {
+		summary += "\n" + tr(langOf(message), "preview.limit", limit, len(entries))
+	}
+	text := summary + "\n\n" + strings.Join(lines, "\n")
+	if len(tracks) == 0 {
+		text += "\n\n" + tr(langOf(message), "preview.hint", url)
+	}
+
+	id := nextPendingID()
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.DisableWebPagePreview = true
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(trn(langOf(message), "preview.download", count), "confirm:"+id),
+			tgbotapi.NewInlineKeyboardButtonData(tr(langOf(message), "confirm.cancel"), "reject:"+id),
+		),
+	)
+	prompt, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return
+	}
+
+	_, opts := parseRequest(message.Chat.ID, url)
+	opts.Playlist = true
+	opts.Tracks = tracks
+	req := &pendingRequest{message: message, url: url, opts: opts, promptID: prompt.MessageID}
+	storePending(bot, id, req, text)
+}
+
+type indexedEntry struct {
+	playlistEntry
+	index int // 1-based position in the playlist
+}
+
+// selectTracks returns the entries picked by tracks, in playlist order, or
+// all of them when tracks is empty.
+func selectTracks(entries []playlistEntry, tracks []int) []indexedEntry {
+	wanted := make(map[int]bool)
+	for _, track := range tracks {
+		wanted[track] = true
+	}
+
+	var selected []indexedEntry
+	for i, entry := range entries {
+		if len(tracks) == 0 || wanted[i+1] {
+			selected = append(selected, indexedEntry{entry, i + 1})
+		}
+	}
+	return selected
+}
+
+// parseTrackSelection reads a list like "1-3,7" into track numbers, or
+// explains to the user, in lang, what is wrong with it.
+func parseTrackSelection(lang string, selection string) ([]int, string) {
+	var tracks []int
+	for _, part := range strings.Split(selection, ",") {
+		if part == "" {
+			continue
+		}
+		first, last, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(first)
+		if err != nil || from < 1 {
+			return nil, tr(lang, "preview.bad_selection", part)
+		}
+		to := from
+		if isRange {
+			to, err = strconv.Atoi(last)
+			if err != nil || to < from {
+				return nil, tr(lang, "preview.bad_selection", part)
+			}
+		}
+		if to-from >= 1000 {
+			return nil, tr(lang, "preview.range_too_large", part)
+		}
+		for track := from; track <= to; track++ {
+			tracks = append(tracks, track)
+		}
+	}
+	if len(tracks) == 0 {
+		return nil, tr(lang, "preview.nothing_selected")
+	}
+	return tracks, ""
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+)
+
+type chatPrefs struct {
+	Lang         string `json:"lang,omitempty"`
+	SubtitleLang string `json:"subtitle-lang,omitempty"`
+	SampleRate   int    `json:"sample-rate,omitempty"`
+	Channels     int    `json:"channels,omitempty"`
+	Bitrate      int    `json:"bitrate,omitempty"`
+	Zip          bool   `json:"zip,omitempty"`
+	AutoPlaylist *bool  `json:"auto-playlist,omitempty"`
+	TrimSilence  *bool  `json:"trim-silence,omitempty"`
+	Fade         *bool  `json:"fade,omitempty"`
+
+	AAC        *bool    `json:"aac,omitempty"`
+	AACQuality *float64 `json:"aac-quality,omitempty"`
+	Speed      *float64 `json:"speed,omitempty"`
+
+	Last *historyEntry `json:"last,omitempty"`
+}
+
+type dailyCount struct {
+	Date  string `json:"date"`
+	Count int    `json:"count"`
+}
+
+type prefsData struct {
+	Chats map[int64]*chatPrefs  `json:"chats"`
+	Daily map[int64]*dailyCount `json:"daily,omitempty"`
+
+	History map[int64][]historyEntry `json:"history,omitempty"`
+	Stats   map[int64]*userStats     `json:"stats,omitempty"`
+}
+
+type prefsStore struct {
+	mu   sync.Mutex
+	path string
+	data prefsData
+}
+
+func loadPrefs(path string) (*prefsStore, error) {
+	store := &prefsStore{
+		path: path,
+		data: prefsData{Chats: make(map[int64]*chatPrefs), Daily: make(map[int64]*dailyCount), History: make(map[int64][]historyEntry), Stats: make(map[int64]*userStats)},
+	}
+
+	raw, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read prefs: %v", err)
+	}
+
+	if err := json.Unmarshal(raw, &store.data); err != nil {
+		return nil, fmt.Errorf("could not parse prefs: %v", err)
+	}
+	if store.data.Chats == nil {
+		store.data.Chats = make(map[int64]*chatPrefs)
+	}
+	if store.data.Daily == nil {
+		store.data.Daily = make(map[int64]*dailyCount)
+	}
+	if store.data.History == nil {
+		store.data.History = make(map[int64][]historyEntry)
+	}
+	if store.data.Stats == nil {
+		store.data.Stats = make(map[int64]*userStats)
+	}
+
+	return store, nil
+}
+
+func (s *prefsStore) get(chatID int64) chatPrefs {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p, ok := s.data.Chats[chatID]; ok {
+		return *p
+	}
+	return chatPrefs{}
+}
+
+func (s *prefsStore) update(chatID int64, fn func(p *chatPrefs)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.data.Chats[chatID]
+	if !ok {
+		p = &chatPrefs{}
+		s.data.Chats[chatID] = p
+	}
+	fn(p)
+
+	return s.save()
+}
+
+func (s *prefsStore) dailyUsage(userID int64, date string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.data.Daily[userID]; ok && c.Date == date {
+		return c.Count
+	}
+	return 0
+}
+
+// takeDaily adds n to the user's count for date unless that would take it
+// past limit, and reports whether it did.
+func (s *prefsStore) takeDaily(userID int64, date string, limit int, n int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.data.Daily[userID]
+	if !ok || c.Date != date {
+		c = &dailyCount{Date: date}
+		s.data.Daily[userID] = c
+	}
+	if c.Count+n > limit {
+		return false, nil
+	}
+	c.Count += n
+
+	return true, s.save()
+}
+
+// giveBackDaily takes n off the user's count for date; a count that has
+// already moved on to a later day is left alone.
+func (s *prefsStore) giveBackDaily(userID int64, date string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.data.Daily[userID]
+	if !ok || c.Date != date {
+		return nil
+	}
+	c.Count = max(c.Count-n, 0)
+
+	return s.save()
+}
+
+func (s *prefsStore) save() error {
+	raw, err := json.MarshalIndent(s.data, "", "    ")
+	if err != nil {
+		return fmt.Errorf("could not encode prefs: %v", err)
+	}
+
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0644); err != nil {
+		return fmt.Errorf("could not write prefs: %v", err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("could not write prefs: %v", err)
+	}
+
+	return nil
+}
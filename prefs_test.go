@@ -0,0 +1,41 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestGiveBackDaily(t *testing.T) {
+	store, err := loadPrefs(filepath.Join(t.TempDir(), "prefs.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := store.takeDaily(1, "2026-10-14", 5, 3); !ok || err != nil {
+		t.Fatalf("takeDaily = %v, %v, want true", ok, err)
+	}
+	if err := store.giveBackDaily(1, "2026-10-14", 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.dailyUsage(1, "2026-10-14"); got != 1 {
+		t.Fatalf("usage after giving 2 back = %d, want 1", got)
+	}
+
+	// Giving back what yesterday took leaves today's count alone.
+	if ok, _ := store.takeDaily(1, "2026-10-15", 5, 1); !ok {
+		t.Fatal("takeDaily refused on a new day")
+	}
+	if err := store.giveBackDaily(1, "2026-10-14", 1); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.dailyUsage(1, "2026-10-15"); got != 1 {
+		t.Fatalf("usage after giving back yesterday's = %d, want 1", got)
+	}
+
+	if err := store.giveBackDaily(1, "2026-10-15", 5); err != nil {
+		t.Fatal(err)
+	}
+	if got := store.dailyUsage(1, "2026-10-15"); got != 0 {
+		t.Fatalf("usage after giving back too much = %d, want 0", got)
+	}
+}
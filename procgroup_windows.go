@@ -0,0 +1,10 @@
+package main
+
+import "os/exec"
+
+// Process groups aren't used on Windows; only the process itself is killed.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
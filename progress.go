@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	progressBarWidth  = 10
+	progressEditEvery = 3 * time.Second
+)
+
+// yt-dlp --progress-template lines, kept machine-readable so they don't
+// depend on the console progress format.
+const (
+	progressLinePrefix      = "[progress]"
+	postprocessLinePrefix   = "[postprocess]"
+	downloadProgressTmpl    = "download:" + progressLinePrefix + " %(progress._percent_str)s|%(progress._speed_str)s|%(progress._eta_str)s|%(progress._total_bytes_str)s|%(progress._total_bytes_estimate_str)s"
+	postprocessProgressTmpl = "postprocess:" + postprocessLinePrefix + " %(progress.status)s|%(progress.postprocessor)s"
+)
+
+type downloadProgress struct {
+	Percent    float64
+	Speed      string
+	ETA        string
+	Total      string
+	Processing bool
+}
+
+type jobPhase int
+
+const (
+	phaseStarting jobPhase = iota
+	phaseDownloading
+	phaseProcessing
+	phaseUploading
+)
+
+// jobState is what the status message shows. Progress callbacks write it and
+// the status editor renders it, so every phase is described in one place.
+type jobState struct {
+	Phase   jobPhase
+	Percent float64
+	Speed   string
+	ETA     string
+	Total   string
+
+	// Part and Parts are only set while uploading split files.
+	Part  int
+	Parts int
+	Size  int64
+}
+
+// text renders the state in lang.
+func (s jobState) text(lang string) string {
+	switch s.Phase {
+	case phaseDownloading:
+		head := tr(lang, "status.downloading", s.Percent)
+		if s.Total != "" {
+			head += tr(lang, "status.total", s.Total)
+		}
+		if s.ETA != "" {
+			head += tr(lang, "status.eta", s.ETA)
+		}
+		text := head + "\n" + renderProgressBar(s.Percent)
+		if s.Speed != "" {
+			text += "\n" + s.Speed
+		}
+		return text
+	case phaseProcessing:
+		return tr(lang, "status.processing")
+	case phaseUploading:
+		text := tr(lang, "status.uploading")
+		if s.Parts > 0 {
+			text += tr(lang, "status.part", s.Part, s.Parts)
+		}
+		if s.Size > 0 {
+			text += " (" + formatSize(s.Size) + ")"
+		}
+		return text + "..."
+	default:
+		return tr(lang, "status.starting")
+	}
+}
+
+func renderProgressBar(percent float64) string {
+	percent = max(0, min(100, percent))
+	filled := int(percent / 100 * progressBarWidth)
+	return fmt.Sprintf("[%s%s] %.0f%%", strings.Repeat("█", filled), strings.Repeat("░", progressBarWidth-filled), percent)
+}
+
+// parseProgressLine turns a line printed through the progress templates into
+// a progress update. The download phase ends once the file reaches 100%;
+// yt-dlp then hands over to ffmpeg, which reports no percentage of its own.
+func parseProgressLine(line string) (downloadProgress, bool) {
+	line = strings.TrimSpace(line)
+
+	if rest, ok := strings.CutPrefix(line, postprocessLinePrefix); ok {
+		if strings.TrimSpace(rest) == "" {
+			return downloadProgress{}, false
+		}
+		return downloadProgress{Percent: 100, Processing: true}, true
+	}
+
+	rest, ok := strings.CutPrefix(line, progressLinePrefix)
+	if !ok {
+		return downloadProgress{}, false
+	}
+	fields := strings.Split(strings.TrimSpace(rest), "|")
+	if len(fields) != 5 {
+		return downloadProgress{}, false
+	}
+
+	percent, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(fields[0]), "%"), 64)
+	if err != nil {
+		return downloadProgress{}, false
+	}
+	return downloadProgress{
+		Percent:    percent,
+		Processing: percent >= 100,
+		Speed:      knownValue(fields[1]),
+		ETA:        knownValue(fields[2]),
+		Total:      totalSize(fields[3], fields[4]),
+	}, true
+}
+
+// totalSize prefers the exact size and falls back to yt-dlp's estimate, which
+// is all there is for fragmented downloads.
+func totalSize(exact string, estimate string) string {
+	if total := knownValue(exact); total != "" {
+		return total
+	}
+	if total := knownValue(estimate); total != "" {
+		return "~" + total
+	}
+	return ""
+}
+
+func knownValue(value string) string {
+	value = strings.TrimSpace(value)
+	if value == "NA" || strings.HasPrefix(value, "Unknown") {
+		return ""
+	}
+	return value
+}
+
+// statusEditor keeps a status message showing the latest jobState. Updates
+// are coalesced by a goroutine that edits at most once every
+// progressEditEvery, skips edits that wouldn't change the text and waits
+// out Telegram's rate limits, so the job itself never blocks on an edit.
+type statusEditor struct {
+	bot       *tgbotapi.BotAPI
+	chatID    int64
+	lang      string
+	messageID int
+
+	// keyboard stays on the message until the upload starts.
+	keyboard *tgbotapi.InlineKeyboardMarkup
+	started  time.Time
+
+	mu    sync.Mutex
+	state jobState
+
+	wake     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+func newStatusEditor(bot *tgbotapi.BotAPI, chatID int64, lang string, messageID int, keyboard *tgbotapi.InlineKeyboardMarkup) *statusEditor {
+	e := &statusEditor{
+		bot:       bot,
+		chatID:    chatID,
+		lang:      lang,
+		messageID: messageID,
+		keyboard:  keyboard,
+		started:   time.Now(),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if messageID == 0 {
+		close(e.done)
+		return e
+	}
+	go e.run()
+	return e
+}
+
+func (e *statusEditor) run() {
+	defer close(e.done)
+
+	lastText := jobState{}.text(e.lang) // what the message was sent with
+	next := time.Now()
+	for {
+		select {
+		case <-e.stop:
+			return
+		case <-e.wake:
+		}
+		if wait := time.Until(next); wait > 0 {
+			select {
+			case <-e.stop:
+				return
+			case <-time.After(wait):
+			}
+		}
+
+		e.mu.Lock()
+		text := e.state.text(e.lang)
+		uploading := e.state.Phase == phaseUploading
+		e.mu.Unlock()
+		if text == lastText {
+			continue
+		}
+
+		edit := tgbotapi.NewEditMessageText(e.chatID, e.messageID, text)
+		if !uploading {
+			edit.ReplyMarkup = e.keyboard
+		}
+		throttle(edit)
+		_, err := e.bot.Send(edit)
+		next = time.Now().Add(progressEditEvery)
+		if err == nil {
+			lastText = text
+			continue
+		}
+
+		var tgErr *tgbotapi.Error
+		switch {
+		case errors.As(err, &tgErr) && tgErr.RetryAfter > 0:
+			// Try again once allowed, with whatever the state is by then.
+			next = time.Now().Add(time.Duration(tgErr.RetryAfter) * time.Second)
+			e.poke()
+		case strings.Contains(err.Error(), "message is not modified"):
+			lastText = text
+		case strings.Contains(err.Error(), "message to edit not found"), strings.Contains(err.Error(), "message can't be edited"):
+			// Deleted by the user; the job carries on without a status.
+			log.Println("Status message is gone, no longer updating it")
+			return
+		default:
+			log.Println("Error updating status:", err)
+		}
+	}
+}
+
+func (e *statusEditor) poke() {
+	select {
+	case e.wake <- struct{}{}:
+	default:
+	}
+}
+
+// set changes the job state; the status message catches up shortly.
+func (e *statusEditor) set(change func(s *jobState)) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	change(&e.state)
+	e.mu.Unlock()
+	e.poke()
+}
+
+// progress returns a callback for downloadOptions.Progress.
+func (e *statusEditor) progress() func(downloadProgress) {
+	return func(p downloadProgress) {
+		e.set(func(s *jobState) {
+			if p.Processing {
+				s.Phase = phaseProcessing
+				return
+			}
+			s.Phase = phaseDownloading
+			s.Percent, s.Speed, s.ETA, s.Total = p.Percent, p.Speed, p.ETA, p.Total
+		})
+	}
+}
+
+// uploading shows that size bytes are being sent; part and parts are zero
+// for a single file.
+func (e *statusEditor) uploading(part int, parts int, size int64) {
+	e.set(func(s *jobState) {
+		s.Phase = phaseUploading
+		s.Part, s.Parts, s.Size = part, parts, size
+	})
+}
+
+// close stops editing and waits for an edit in flight to finish.
+func (e *statusEditor) close() {
+	if e == nil {
+		return
+	}
+	e.stopOnce.Do(func() { close(e.stop) })
+	<-e.done
+}
+
+// replace stops editing and leaves text as the final status. It reports
+// false if there is no status message to show it in.
+func (e *statusEditor) replace(text string) bool {
+	if e == nil || e.messageID == 0 {
+		return false
+	}
+	e.close()
+
+	edit := tgbotapi.NewEditMessageText(e.chatID, e.messageID, text)
+	if _, err := sendMessage(e.bot, edit); err != nil {
+		log.Println("Error updating status:", err)
+		return false
+	}
+	return true
+}
+
+// finish clears up the status once the result has been delivered: it is
+// deleted, or with status-summary turned into a note of how long it took.
+func (e *statusEditor) finish() {
+	if conf.StatusSummary {
+		e.replace(tr(e.lang, "status.done", time.Since(e.started).Round(time.Second)))
+		return
+	}
+	e.remove()
+}
+
+// remove deletes the status message once the result has been delivered, so
+// the chat is left with just the audio.
+func (e *statusEditor) remove() {
+	if e == nil || e.messageID == 0 {
+		return
+	}
+	e.close()
+
+	deletion := tgbotapi.NewDeleteMessage(e.chatID, e.messageID)
+	throttle(deletion)
+	if _, err := e.bot.Request(deletion); err != nil {
+		log.Println("Error deleting status:", err)
+	}
+}
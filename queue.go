@@ -0,0 +1,329 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultMaxConcurrentDownloads = 2
+
+	queueUpdateEvery = 15 * time.Second
+	recentJobsKept   = 20
+)
+
+type queuedJob struct {
+	chatID   int64
+	userID   int64
+	url      string
+	title    string
+	enqueued time.Time
+	started  time.Time
+	start    chan struct{}
+	removed  chan struct{}
+
+	// paused is set while the job has given up its slot.
+	paused bool
+}
+
+type jobQueue struct {
+	mu      sync.Mutex
+	limit   int
+	running int
+	waiting []*queuedJob
+
+	// recent holds how long the last few jobs ran, for wait estimates.
+	recent []time.Duration
+}
+
+var downloads = &jobQueue{limit: defaultMaxConcurrentDownloads}
+
+func newQueuedJob(message *tgbotapi.Message, url string, info *videoInfo) *queuedJob {
+	job := &queuedJob{
+		chatID:   message.Chat.ID,
+		url:      url,
+		enqueued: time.Now(),
+		start:    make(chan struct{}),
+		removed:  make(chan struct{}),
+	}
+	if message.From != nil {
+		job.userID = message.From.ID
+	}
+	if info != nil {
+		job.title = info.Title
+	}
+	return job
+}
+
+// enqueue returns the number of jobs ahead of this one, or 0 if it may start
+// right away.
+func (q *jobQueue) enqueue(job *queuedJob) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.running < q.limit && len(q.waiting) == 0 {
+		q.running++
+		job.started = time.Now()
+		close(job.start)
+		return 0
+	}
+
+	q.waiting = append(q.waiting, job)
+	return len(q.waiting)
+}
+
+// wait blocks until the job may run and reports false if it was removed from
+// the queue instead.
+func (q *jobQueue) wait(job *queuedJob) bool {
+	select {
+	case <-job.start:
+		return true
+	case <-job.removed:
+		return false
+	}
+}
+
+func (q *jobQueue) release(job *queuedJob) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.recent = append(q.recent, time.Since(job.started))
+	if len(q.recent) > recentJobsKept {
+		q.recent = q.recent[1:]
+	}
+
+	if !job.paused {
+		q.running--
+	}
+	q.startWaitingLocked()
+}
+
+func (q *jobQueue) startWaitingLocked() {
+	for q.running < q.limit && len(q.waiting) > 0 {
+		next := q.waiting[0]
+		q.waiting = q.waiting[1:]
+		q.running++
+		next.started = time.Now()
+		close(next.start)
+	}
+}
+
+// pause gives up the slot a running job holds while it waits on the user
+// rather than doing any work. The returned func blocks until it has a slot
+// again; having waited its turn once, it goes ahead of the queue. It reports
+// false if stop is closed first, and the job then finishes without a slot. A
+// nil job holds no slot.
+func (q *jobQueue) pause(job *queuedJob) func(stop <-chan struct{}) bool {
+	if job == nil {
+		return func(<-chan struct{}) bool { return true }
+	}
+	q.mu.Lock()
+	q.running--
+	job.paused = true
+	q.startWaitingLocked()
+	q.mu.Unlock()
+
+	return func(stop <-chan struct{}) bool {
+		// Nobody else can see this placeholder or take it out of the queue.
+		slot := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+		q.mu.Lock()
+		if q.running < q.limit {
+			q.running++
+			close(slot.start)
+		} else {
+			q.waiting = append([]*queuedJob{slot}, q.waiting...)
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-slot.start:
+		case <-stop:
+			if q.remove(slot) {
+				return false
+			}
+			// The slot came free just as the job was stopped.
+		}
+		q.mu.Lock()
+		job.paused = false
+		q.mu.Unlock()
+		return true
+	}
+}
+
+// position returns the job's 1-based place in the queue, or 0 once it has
+// left it.
+func (q *jobQueue) position(job *queuedJob) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiting := range q.waiting {
+		if waiting == job {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+// estimate roughly guesses how long a job at position will wait, from the
+// average length of recent jobs; it reports false without any to go by.
+func (q *jobQueue) estimate(position int) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.recent) == 0 {
+		return 0, false
+	}
+	var total time.Duration
+	for _, took := range q.recent {
+		total += took
+	}
+	average := total / time.Duration(len(q.recent))
+	return average * time.Duration(position) / time.Duration(max(q.limit, 1)), true
+}
+
+func queuedText(lang string, position int) string {
+	text := tr(lang, "queue.position", position)
+	if wait, ok := downloads.estimate(position); ok {
+		text += trn(lang, "queue.estimate", max(int(wait.Round(time.Minute).Minutes()), 1))
+	}
+	return text + tr(lang, "queue.position_hint")
+}
+
+// reportQueuePosition tells the user a job has to wait and keeps that reply
+// up to date until the job starts or leaves the queue.
+func reportQueuePosition(bot *tgbotapi.BotAPI, message *tgbotapi.Message, job *queuedJob, position int) {
+	if position == 0 {
+		return
+	}
+	text := queuedText(langOf(message), position)
+	msg := tgbotapi.NewMessage(message.Chat.ID, text)
+	msg.ReplyToMessageID = replyTarget(message)
+	sent, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+		return
+	}
+
+	update := func(newText string) {
+		if newText == text {
+			return
+		}
+		text = newText
+		edit := tgbotapi.NewEditMessageText(message.Chat.ID, sent.MessageID, text)
+		if _, err := sendMessage(bot, edit); err != nil {
+			log.Println("Error updating queue position:", err)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(queueUpdateEvery)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-job.start:
+				update(tr(langOf(message), "queue.started"))
+				return
+			case <-job.removed:
+				update(tr(langOf(message), "queue.removed"))
+				return
+			case <-ticker.C:
+				// Zero means it just left the queue; start or removed says how.
+				if position := downloads.position(job); position > 0 {
+					update(queuedText(langOf(message), position))
+				}
+			}
+		}
+	}()
+}
+
+func (q *jobQueue) pendingFor(userID int64) []*queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var jobs []*queuedJob
+	for _, job := range q.waiting {
+		if job.userID == userID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func (q *jobQueue) pendingInChat(chatID int64) []*queuedJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var jobs []*queuedJob
+	for _, job := range q.waiting {
+		if job.chatID == chatID {
+			jobs = append(jobs, job)
+		}
+	}
+	return jobs
+}
+
+func (q *jobQueue) remove(job *queuedJob) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, waiting := range q.waiting {
+		if waiting == job {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			close(job.removed)
+			return true
+		}
+	}
+	return false
+}
+
+func handleQueue(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	if message.From == nil {
+		return
+	}
+	fields := strings.Fields(args)
+	jobs := downloads.pendingFor(message.From.ID)
+
+	if len(fields) == 0 {
+		if len(jobs) == 0 {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "queue.empty"))
+			return
+		}
+
+		var sb strings.Builder
+		sb.WriteString(tr(langOf(message), "queue.header") + "\n")
+		for i, job := range jobs {
+			name := job.title
+			if name == "" {
+				name = job.url
+			}
+			sb.WriteString(tr(langOf(message), "queue.entry", i+1, name, formatDuration(int(time.Since(job.enqueued).Seconds()))) + "\n")
+		}
+		sb.WriteString("\n" + tr(langOf(message), "queue.hint"))
+		sendText(bot, message.Chat.ID, sb.String())
+		return
+	}
+
+	if fields[0] != "remove" || len(fields) != 2 {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "queue.usage"))
+		return
+	}
+
+	index, err := strconv.Atoi(fields[1])
+	if err != nil || index < 1 || index > len(jobs) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "queue.no_such"))
+		return
+	}
+
+	if !downloads.remove(jobs[index-1]) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "queue.already_started"))
+		return
+	}
+
+	log.Printf("Removed queued download %s for user %d", jobs[index-1].url, message.From.ID)
+	sendText(bot, message.Chat.ID, tr(langOf(message), "queue.removed_one", index))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJobQueuePauseLendsTheSlot(t *testing.T) {
+	q := &jobQueue{limit: 1}
+	running := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	waiting := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	later := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	if q.enqueue(running) != 0 {
+		t.Fatal("first job had to wait")
+	}
+	q.enqueue(waiting)
+
+	resume := q.pause(running)
+	select {
+	case <-waiting.start:
+	case <-time.After(time.Second):
+		t.Fatal("waiting job didn't start while the running one was paused")
+	}
+	q.enqueue(later)
+
+	resumed := make(chan struct{})
+	go func() {
+		resume(nil)
+		close(resumed)
+	}()
+	select {
+	case <-resumed:
+		t.Fatal("paused job resumed while the slot was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The paused job goes first, ahead of the one queued after it.
+	q.release(waiting)
+	select {
+	case <-resumed:
+	case <-time.After(time.Second):
+		t.Fatal("paused job didn't resume once the slot was free")
+	}
+	select {
+	case <-later.start:
+		t.Fatal("a later job jumped ahead of the paused one")
+	default:
+	}
+
+	q.release(running)
+	select {
+	case <-later.start:
+	case <-time.After(time.Second):
+		t.Fatal("later job didn't start")
+	}
+}
+
+func TestJobQueuePauseWithoutSlot(t *testing.T) {
+	q := &jobQueue{limit: 1}
+	q.pause(nil)(nil)
+	if q.running != 0 {
+		t.Fatalf("running = %d after pausing no job, want 0", q.running)
+	}
+}
+
+func TestJobQueueResumeStopped(t *testing.T) {
+	q := &jobQueue{limit: 1}
+	running := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	waiting := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	later := &queuedJob{start: make(chan struct{}), removed: make(chan struct{})}
+	q.enqueue(running)
+	q.enqueue(waiting)
+
+	resume := q.pause(running)
+	<-waiting.start
+	q.enqueue(later)
+
+	stop := make(chan struct{})
+	close(stop)
+	if resume(stop) {
+		t.Fatal("resume took a slot after being stopped")
+	}
+	if len(q.waiting) != 1 || q.waiting[0] != later {
+		t.Fatalf("stopped job left a placeholder in the queue: %d waiting", len(q.waiting))
+	}
+
+	// The stopped job gives back no slot, so only the waiting one frees one.
+	q.release(running)
+	select {
+	case <-later.start:
+		t.Fatal("later job started while the slot was still taken")
+	default:
+	}
+	q.release(waiting)
+	select {
+	case <-later.start:
+	case <-time.After(time.Second):
+		t.Fatal("later job didn't start")
+	}
+	if q.running != 1 {
+		t.Fatalf("running = %d, want 1", q.running)
+	}
+}
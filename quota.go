@@ -0,0 +1,77 @@
+package main
+
+import (
+	"log"
+	"math"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func isAdmin(userID int64) bool {
+	for _, id := range conf.AdminIDs {
+		if id == userID {
+			return true
+		}
+	}
+	return false
+}
+
+func quotaExempt(message *tgbotapi.Message) bool {
+	return conf.DailyQuota <= 0 || message.From == nil || isAdmin(message.From.ID)
+}
+
+func quotaMessage(lang string) string {
+	return tr(lang, "quota.reached", conf.DailyQuota, trn(lang, "quota.hours", hoursUntilReset(time.Now())))
+}
+
+// hoursUntilReset rounds the time left until the quota resets at midnight
+// UTC up to whole hours.
+func hoursUntilReset(now time.Time) int {
+	now = now.UTC()
+	midnight := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return int(math.Ceil(midnight.Sub(now).Hours()))
+}
+
+// checkQuota reports whether the user still has downloads left today without
+// using one up.
+func checkQuota(message *tgbotapi.Message) string {
+	if quotaExempt(message) {
+		return ""
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	if prefs.dailyUsage(message.From.ID, today) >= conf.DailyQuota {
+		return quotaMessage(langOf(message))
+	}
+	return ""
+}
+
+func takeQuota(message *tgbotapi.Message) (string, func()) {
+	return takeQuotaFor(message, 1)
+}
+
+// takeQuotaFor uses up one download per video of a playlist or audiobook,
+// all at once so a long playlist can't start on the last few left. The
+// returned func gives them back, for a job that leaves the queue without
+// running.
+func takeQuotaFor(message *tgbotapi.Message, videos int) (string, func()) {
+	if quotaExempt(message) {
+		return "", func() {}
+	}
+	today := time.Now().UTC().Format("2006-01-02")
+	ok, err := prefs.takeDaily(message.From.ID, today, conf.DailyQuota, videos)
+	if err != nil {
+		log.Println("Error saving quota:", err)
+	}
+	if !ok {
+		if left := conf.DailyQuota - prefs.dailyUsage(message.From.ID, today); videos > 1 && left > 0 {
+			return trn(langOf(message), "quota.not_enough", left, videos), nil
+		}
+		return quotaMessage(langOf(message)), nil
+	}
+	return "", func() {
+		if err := prefs.giveBackDaily(message.From.ID, today, videos); err != nil {
+			log.Println("Error saving quota:", err)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHoursUntilReset(t *testing.T) {
+	tests := []struct {
+		now  string
+		want int
+	}{
+		{"2026-10-14T23:50:00Z", 1},
+		{"2026-10-14T21:00:00Z", 3},
+		{"2026-10-14T20:59:59Z", 4},
+		{"2026-10-14T00:00:00Z", 24},
+		{"2026-10-15T01:30:00+02:00", 1}, // 23:30 UTC
+	}
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := hoursUntilReset(now); got != tt.want {
+			t.Errorf("hoursUntilReset(%s) = %d, want %d", tt.now, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Telegram allows about one message per second in a chat and thirty per
+// second overall before it starts answering 429.
+const (
+	chatRatePerSecond   = 1
+	chatBurst           = 3
+	globalRatePerSecond = 30
+	globalBurst         = 30
+	maxIdleChatBuckets  = 1000
+)
+
+type clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time        { return time.Now() }
+func (realClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+type tokenBucket struct {
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+}
+
+// reserve takes a token, going into debt if there is none, and returns how
+// long the caller has to wait for it. Debt makes callers queue up in order
+// instead of all waking at once.
+func (b *tokenBucket) reserve(now time.Time) time.Duration {
+	b.refill(now)
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	clock  clock
+	global *tokenBucket
+	chats  map[int64]*tokenBucket
+}
+
+func newRateLimiter(c clock) *rateLimiter {
+	return &rateLimiter{
+		clock:  c,
+		global: newTokenBucket(globalRatePerSecond, globalBurst, c.Now()),
+		chats:  make(map[int64]*tokenBucket),
+	}
+}
+
+var outgoing = newRateLimiter(realClock{})
+
+// wait blocks until a message to chatID may go out; chatID 0 only counts
+// against the global limit.
+func (l *rateLimiter) wait(chatID int64) {
+	l.mu.Lock()
+	now := l.clock.Now()
+	delay := l.global.reserve(now)
+	if chatID != 0 {
+		bucket, ok := l.chats[chatID]
+		if !ok {
+			l.pruneLocked(now)
+			bucket = newTokenBucket(chatRatePerSecond, chatBurst, now)
+			l.chats[chatID] = bucket
+		}
+		delay = max(delay, bucket.reserve(now))
+	}
+	l.mu.Unlock()
+
+	if delay > 0 {
+		l.clock.Sleep(delay)
+	}
+}
+
+// pruneLocked forgets chats whose bucket has refilled completely, since a
+// fresh bucket behaves the same.
+func (l *rateLimiter) pruneLocked(now time.Time) {
+	if len(l.chats) < maxIdleChatBuckets {
+		return
+	}
+	for chatID, bucket := range l.chats {
+		bucket.refill(now)
+		if bucket.tokens >= bucket.burst {
+			delete(l.chats, chatID)
+		}
+	}
+}
+
+// chatIDOf finds the chat a request is addressed to, for the per-chat limit.
+func chatIDOf(c tgbotapi.Chattable) int64 {
+	switch v := c.(type) {
+	case tgbotapi.MessageConfig:
+		return v.ChatID
+	case tgbotapi.AudioConfig:
+		return v.ChatID
+	case tgbotapi.DocumentConfig:
+		return v.ChatID
+	case tgbotapi.PhotoConfig:
+		return v.ChatID
+	case tgbotapi.VoiceConfig:
+		return v.ChatID
+	case tgbotapi.ChatActionConfig:
+		return v.ChatID
+	case tgbotapi.EditMessageTextConfig:
+		return v.ChatID
+	case tgbotapi.EditMessageReplyMarkupConfig:
+		return v.ChatID
+	case tgbotapi.DeleteMessageConfig:
+		return v.ChatID
+	}
+	return 0
+}
+
+func throttle(c tgbotapi.Chattable) {
+	outgoing.wait(chatIDOf(c))
+}
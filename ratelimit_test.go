@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock only moves when told to and records the sleeps it is asked for
+// instead of sleeping.
+type fakeClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time        { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) { c.sleeps = append(c.sleeps, d) }
+
+func (c *fakeClock) advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// waited calls l.wait and returns how long it blocked, zero if it didn't.
+func (c *fakeClock) waited(l *rateLimiter, chatID int64) time.Duration {
+	before := len(c.sleeps)
+	l.wait(chatID)
+	if len(c.sleeps) == before {
+		return 0
+	}
+	return c.sleeps[len(c.sleeps)-1]
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestRateLimiterChatBurstAndRefill(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	for i := 0; i < chatBurst; i++ {
+		if d := c.waited(l, 1); d != 0 {
+			t.Fatalf("message %d of the burst waited %v", i+1, d)
+		}
+	}
+	if d := c.waited(l, 1); d != time.Second {
+		t.Fatalf("message past the burst waited %v, want 1s", d)
+	}
+
+	// One second pays off the debt, the next one refills a single token.
+	c.advance(2 * time.Second)
+	if d := c.waited(l, 1); d != 0 {
+		t.Fatalf("message after refill waited %v", d)
+	}
+	if d := c.waited(l, 1); d != time.Second {
+		t.Fatalf("second message after a one-token refill waited %v, want 1s", d)
+	}
+
+	// A long pause refills the bucket only up to the burst.
+	c.advance(time.Hour)
+	for i := 0; i < chatBurst; i++ {
+		if d := c.waited(l, 1); d != 0 {
+			t.Fatalf("message %d after a long pause waited %v", i+1, d)
+		}
+	}
+	if d := c.waited(l, 1); d != time.Second {
+		t.Fatalf("message past the refilled burst waited %v, want 1s", d)
+	}
+}
+
+func TestRateLimiterChatsAreIndependent(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	for i := 0; i < chatBurst; i++ {
+		c.waited(l, 1)
+	}
+	if d := c.waited(l, 2); d != 0 {
+		t.Fatalf("another chat waited %v for chat 1's burst", d)
+	}
+}
+
+func TestRateLimiterGlobalCap(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	// Every message to a different chat, so only the global bucket drains.
+	for i := 0; i < globalBurst; i++ {
+		if d := c.waited(l, int64(i+1)); d != 0 {
+			t.Fatalf("message %d of the global burst waited %v", i+1, d)
+		}
+	}
+	want := time.Second / globalRatePerSecond
+	if d := c.waited(l, globalBurst+1); d != want {
+		t.Fatalf("message past the global burst waited %v, want %v", d, want)
+	}
+	// Requests without a chat still count against the global limit.
+	if d := c.waited(l, 0); d != 2*want {
+		t.Fatalf("chatless request waited %v, want %v", d, 2*want)
+	}
+}
+
+func TestRateLimiterGlobalDelayWinsOverChat(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	for i := 0; i < globalBurst; i++ {
+		c.waited(l, int64(i+1))
+	}
+	// Chat 1 still has tokens, the global bucket doesn't.
+	if d := c.waited(l, 1); d != time.Second/globalRatePerSecond {
+		t.Fatalf("waited %v, want the global delay", d)
+	}
+}
+
+func TestRateLimiterQueuesWaitersInOrder(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	for i := 0; i < chatBurst; i++ {
+		c.waited(l, 1)
+	}
+	// Without time passing each waiter is sent a second after the one before,
+	// rather than all of them waking at once.
+	for i := 1; i <= 4; i++ {
+		if d := c.waited(l, 1); d != time.Duration(i)*time.Second {
+			t.Fatalf("waiter %d waited %v, want %v", i, d, time.Duration(i)*time.Second)
+		}
+	}
+}
+
+func TestRateLimiterPrunesIdleChats(t *testing.T) {
+	c := newFakeClock()
+	l := newRateLimiter(c)
+
+	for i := 0; i < maxIdleChatBuckets; i++ {
+		c.waited(l, int64(i+1))
+	}
+	c.advance(time.Hour)
+	c.waited(l, maxIdleChatBuckets+1)
+	if len(l.chats) != 1 {
+		t.Fatalf("%d chat buckets left after pruning, want 1", len(l.chats))
+	}
+}
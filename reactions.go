@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	ackMessage  = "message"
+	ackReaction = "reaction"
+)
+
+// Bots may only react with Telegram's fixed set of emoji, which has no ✅,
+// ❌ or ⏳.
+const (
+	reactionAccepted = "👀"
+	reactionDone     = "👍"
+	reactionFailed   = "👎"
+)
+
+// reactionAcks reports whether requests are acknowledged with reactions on
+// the user's message instead of a status message.
+func reactionAcks() bool {
+	return conf.AckStyle == ackReaction
+}
+
+// setReaction replaces the bot's reaction to message with emoji. The library
+// has no wrapper for setMessageReaction, so it is called directly.
+func setReaction(bot *tgbotapi.BotAPI, message *tgbotapi.Message, emoji string) {
+	if !reactionAcks() || message.MessageID == 0 {
+		return
+	}
+	reaction, err := json.Marshal([]map[string]string{{"type": "emoji", "emoji": emoji}})
+	if err != nil {
+		return
+	}
+
+	params := tgbotapi.Params{}
+	params.AddNonZero64("chat_id", message.Chat.ID)
+	params.AddNonZero("message_id", message.MessageID)
+	params["reaction"] = string(reaction)
+
+	outgoing.wait(message.Chat.ID)
+	if _, err := bot.MakeRequest("setMessageReaction", params); err != nil {
+		log.Println("Error setting reaction:", err)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReapIntervalMinutes = 30
+	defaultReapMaxAgeMinutes   = 120
+)
+
+// activeJobs holds the job directories currently in use, so the reaper never
+// deletes files out from under a running download however old they get.
+var activeJobs = struct {
+	sync.Mutex
+	dirs map[string]bool
+}{dirs: make(map[string]bool)}
+
+func markJobActive(dir string) {
+	activeJobs.Lock()
+	defer activeJobs.Unlock()
+	activeJobs.dirs[filepath.Clean(dir)] = true
+}
+
+func markJobDone(dir string) {
+	activeJobs.Lock()
+	defer activeJobs.Unlock()
+	delete(activeJobs.dirs, filepath.Clean(dir))
+}
+
+func isJobActive(path string) bool {
+	activeJobs.Lock()
+	defer activeJobs.Unlock()
+	return activeJobs.dirs[filepath.Clean(path)]
+}
+
+func startReaper(dir string, interval time.Duration, maxAge time.Duration) {
+	reapOrphans(dir, maxAge)
+	go func() {
+		for range time.Tick(interval) {
+			reapOrphans(dir, maxAge)
+		}
+	}()
+}
+
+// reapOrphans removes job directories, and the loose download_* files older
+// versions left in the working directory, that a crash or restart abandoned.
+func reapOrphans(dir string, maxAge time.Duration) {
+	var matches []string
+	for _, pattern := range []string{"job_*", "download_*"} {
+		found, err := filepath.Glob(filepath.Join(globEscape(dir), pattern))
+		if err != nil {
+			log.Println("Error listing orphaned files:", err)
+			return
+		}
+		matches = append(matches, found...)
+	}
+
+	var removed int
+	var reclaimed int64
+	cutoff := time.Now().Add(-maxAge)
+	for _, path := range matches {
+		if isJobActive(path) {
+			continue
+		}
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if fileInfo.ModTime().After(cutoff) || keepTempFiles() {
+			diskUsage.trackFinished(path, fileInfo.ModTime())
+			continue
+		}
+
+		size := pathSize(path)
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Could not remove orphaned %s: %v", path, err)
+			continue
+		}
+		diskUsage.forget(path)
+		removed++
+		reclaimed += size
+	}
+
+	// The converted cache keeps its files past maxAge on purpose; only what
+	// its index has lost track of is removed.
+	cacheRemoved, cacheReclaimed := convertedFiles.reapUnindexed()
+	removed += cacheRemoved
+	reclaimed += cacheReclaimed
+
+	if removed > 0 {
+		log.Printf("Removed %d orphaned download(s), reclaimed %s", removed, formatSize(reclaimed))
+	}
+}
+
+func pathSize(path string) int64 {
+	var total int64
+	filepath.WalkDir(path, func(_ string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const jobManifestName = "job.json"
+
+// jobManifest is written into every single-video job directory so a
+// download interrupted by a restart can be picked up again.
+type jobManifest struct {
+	ChatID    int64  `json:"chat-id"`
+	UserID    int64  `json:"user-id"`
+	MessageID int    `json:"message-id"`
+	URL       string `json:"url"`
+	Section   string `json:"section,omitempty"`
+	FormatID  string `json:"format-id,omitempty"`
+	Zip       bool   `json:"zip,omitempty"`
+	Bitrate   int    `json:"bitrate,omitempty"`
+	StatusID  int    `json:"status-message-id,omitempty"`
+	// Processed names the finished file once post-processing is done, which
+	// a resumed job then sends as it is.
+	Processed string `json:"processed,omitempty"`
+}
+
+func readJobManifest(path string) (jobManifest, error) {
+	var manifest jobManifest
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return manifest, err
+	}
+	err = json.Unmarshal(raw, &manifest)
+	return manifest, err
+}
+
+func writeJobManifest(dir string, manifest jobManifest) error {
+	raw, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, jobManifestName), raw, 0644)
+}
+
+// jobDirFor returns a job directory named after the request rather than
+// at random, so yt-dlp finds its .part files again after a restart. A second
+// identical request running at the same time gets a directory of its own.
+func jobDirFor(message *tgbotapi.Message, url string, opts downloadOptions) (string, error) {
+	sum := sha1.Sum([]byte(fmt.Sprintf("%s|%s|%s", url, opts.Section, opts.FormatID)))
+	dir := filepath.Join(conf.DownloadDir, fmt.Sprintf("job_%d_%s", message.Chat.ID, hex.EncodeToString(sum[:6])))
+	if abs, err := filepath.Abs(dir); err == nil {
+		dir = abs
+	}
+
+	if isJobActive(dir) && !opts.Resumed {
+		return newJobDir(message.Chat.ID)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("could not create job directory: %v", err)
+	}
+	markJobActive(dir)
+	diskUsage.track(dir)
+
+	manifest := jobManifest{ChatID: message.Chat.ID, MessageID: message.MessageID, URL: url, Section: opts.Section, FormatID: opts.FormatID, Zip: opts.Zip, Bitrate: opts.Bitrate, StatusID: opts.StatusID}
+	if message.From != nil {
+		manifest.UserID = message.From.ID
+	}
+	if opts.Resumed {
+		if previous, err := readJobManifest(filepath.Join(dir, jobManifestName)); err == nil {
+			manifest.Processed = previous.Processed
+		}
+	}
+	if err := writeJobManifest(dir, manifest); err != nil {
+		log.Println("Could not write job manifest, this download won't survive a restart:", err)
+	}
+	return dir, nil
+}
+
+// markProcessed records path as the finished file of the job in dir. Jobs
+// without a manifest aren't resumed, so there is nothing to record for them.
+func markProcessed(dir string, path string) {
+	manifestPath := filepath.Join(dir, jobManifestName)
+	manifest, err := readJobManifest(manifestPath)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err == nil {
+		manifest.Processed = filepath.Base(path)
+		err = writeJobManifest(dir, manifest)
+	}
+	if err != nil {
+		log.Println("Could not record the processed file, a resumed job would process it again:", err)
+	}
+}
+
+// processedFile returns the finished file a resumed job left in dir, if it
+// got that far.
+func processedFile(dir string) (string, bool) {
+	manifest, err := readJobManifest(filepath.Join(dir, jobManifestName))
+	if err != nil || manifest.Processed == "" {
+		return "", false
+	}
+	path := filepath.Join(dir, manifest.Processed)
+	if _, err := os.Stat(path); err != nil {
+		return "", false
+	}
+	return path, true
+}
+
+type interruptedJob struct {
+	dir      string
+	manifest jobManifest
+}
+
+// findInterruptedJobs collects job directories with a manifest that are
+// young enough to be worth resuming. They are marked active straight away so
+// the reaper leaves them alone until they run.
+func findInterruptedJobs(downloadDir string, maxAge time.Duration) []interruptedJob {
+	matches, err := filepath.Glob(filepath.Join(globEscape(downloadDir), "job_*", jobManifestName))
+	if err != nil {
+		log.Println("Error looking for interrupted downloads:", err)
+		return nil
+	}
+
+	var jobs []interruptedJob
+	for _, path := range matches {
+		fileInfo, err := os.Stat(path)
+		if err != nil || time.Since(fileInfo.ModTime()) > maxAge {
+			continue
+		}
+		manifest, err := readJobManifest(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil || manifest.URL == "" {
+			log.Printf("Ignoring unreadable job manifest %s: %v", path, err)
+			continue
+		}
+		dir := filepath.Dir(path)
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		markJobActive(dir)
+		jobs = append(jobs, interruptedJob{dir: dir, manifest: manifest})
+	}
+	return jobs
+}
+
+func resumeJobs(bot *tgbotapi.BotAPI, jobs []interruptedJob) {
+	for _, job := range jobs {
+		m := job.manifest
+		log.Printf("Resuming interrupted download of %s for chat %d", m.URL, m.ChatID)
+
+		message := &tgbotapi.Message{
+			MessageID: m.MessageID,
+			Chat:      &tgbotapi.Chat{ID: m.ChatID},
+		}
+		if m.UserID != 0 {
+			message.From = &tgbotapi.User{ID: m.UserID}
+		}
+		opts := downloadOptions{Section: m.Section, FormatID: m.FormatID, Zip: m.Zip, Resumed: true, Bitrate: m.Bitrate, StatusID: m.StatusID}
+
+		// A job with a status message carries on in it instead.
+		if m.StatusID == 0 {
+			sendText(bot, m.ChatID, tr(langOf(message), "resume.restarted", m.URL))
+		}
+		go processDownload(bot, message, m.URL, nil, opts)
+	}
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxSendAttempts  = 4
+	sendRetryBackoff = 2 * time.Second
+	maxSendRetryWait = 2 * time.Minute
+	sendRetryJitter  = time.Second
+)
+
+// sendRetryDelay reports how long to wait before retrying a failed send, or
+// false if the error is permanent.
+func sendRetryDelay(err error, attempt int) (time.Duration, bool) {
+	backoff := sendRetryBackoff * time.Duration(1<<(attempt-1))
+
+	var tgErr *tgbotapi.Error
+	if errors.As(err, &tgErr) {
+		if tgErr.RetryAfter > 0 {
+			// Jitter so parts that hit the limit together don't retry together.
+			jitter := time.Duration(rand.Int63n(int64(sendRetryJitter)))
+			return time.Duration(tgErr.RetryAfter)*time.Second + jitter, true
+		}
+		if tgErr.Code >= 500 {
+			return backoff, true
+		}
+		return 0, false
+	}
+
+	// Anything that isn't an API error is a transport problem: timeouts,
+	// dropped connections, or a proxy returning garbage.
+	return backoff, true
+}
+
+// sendMessage is bot.Send with retries, for anything that can be sent again
+// as-is: text, edits, and files given by path.
+func sendMessage(bot *tgbotapi.BotAPI, c tgbotapi.Chattable) (tgbotapi.Message, error) {
+	return sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+		return c, nil, nil
+	})
+}
+
+// allowMissingReply lets a reply go out as a plain message when the message
+// it answers has been deleted, rather than Telegram rejecting it.
+func allowMissingReply(c tgbotapi.Chattable) tgbotapi.Chattable {
+	switch v := c.(type) {
+	case tgbotapi.MessageConfig:
+		v.AllowSendingWithoutReply = v.ReplyToMessageID != 0
+		return v
+	case tgbotapi.AudioConfig:
+		v.AllowSendingWithoutReply = v.ReplyToMessageID != 0
+		return v
+	case tgbotapi.DocumentConfig:
+		v.AllowSendingWithoutReply = v.ReplyToMessageID != 0
+		return v
+	case tgbotapi.VoiceConfig:
+		v.AllowSendingWithoutReply = v.ReplyToMessageID != 0
+		return v
+	case tgbotapi.PhotoConfig:
+		v.AllowSendingWithoutReply = v.ReplyToMessageID != 0
+		return v
+	}
+	return c
+}
+
+func sendWithRetry(bot *tgbotapi.BotAPI, build func() (tgbotapi.Chattable, func(), error)) (tgbotapi.Message, error) {
+	var lastErr error
+	var waited time.Duration
+	for attempt := 1; attempt <= maxSendAttempts; attempt++ {
+		c, done, err := build()
+		if err != nil {
+			return tgbotapi.Message{}, err
+		}
+		c = allowMissingReply(c)
+		throttle(c)
+		msg, err := bot.Send(c)
+		if done != nil {
+			done()
+		}
+		if err == nil {
+			return msg, nil
+		}
+		lastErr = err
+
+		delay, retry := sendRetryDelay(err, attempt)
+		if !retry || attempt == maxSendAttempts || waited+delay > maxSendRetryWait {
+			break
+		}
+		log.Printf("Send failed (%v), retrying in %s", err, delay)
+		time.Sleep(delay)
+		waited += delay
+	}
+	return tgbotapi.Message{}, lastErr
+}
+
+const retainUploadFor = 30 * time.Minute
+
+type pendingUpload struct {
+	path     string
+	meta     trackMeta
+	document bool
+}
+
+// uploadError is returned when a finished download couldn't be delivered;
+// Remaining lists what still has to be sent, in order.
+type uploadError struct {
+	Remaining []pendingUpload
+	Err       error
+}
+
+func (e *uploadError) Error() string {
+	return fmt.Sprintf("upload failed: %v", e.Err)
+}
+
+func (e *uploadError) Unwrap() error {
+	return e.Err
+}
+
+type retainedUpload struct {
+	dir     string
+	uploads []pendingUpload
+	timer   *time.Timer
+}
+
+// Only the latest failed upload per chat is kept.
+var retainedUploads = struct {
+	sync.Mutex
+	byChat map[int64]*retainedUpload
+}{byChat: make(map[int64]*retainedUpload)}
+
+// retainUpload takes over the job directory so /retry can send the files
+// later; the directory stays marked active, so the reaper leaves it alone,
+// until it's sent or retainUploadFor passes.
+func retainUpload(chatID int64, dir string, uploads []pendingUpload) {
+	retainedUploads.Lock()
+	defer retainedUploads.Unlock()
+
+	if previous, ok := retainedUploads.byChat[chatID]; ok && previous.dir != dir {
+		previous.timer.Stop()
+		removeJobDir(previous.dir)
+	}
+
+	retained := &retainedUpload{dir: dir, uploads: uploads}
+	retained.timer = time.AfterFunc(retainUploadFor, func() {
+		if takeRetainedUpload(chatID, retained) {
+			removeJobDir(dir)
+		}
+	})
+	retainedUploads.byChat[chatID] = retained
+}
+
+// takeRetainedUpload removes the chat's retained upload if it is still r.
+func takeRetainedUpload(chatID int64, r *retainedUpload) bool {
+	retainedUploads.Lock()
+	defer retainedUploads.Unlock()
+	if retainedUploads.byChat[chatID] != r {
+		return false
+	}
+	delete(retainedUploads.byChat, chatID)
+	r.timer.Stop()
+	return true
+}
+
+func handleRetry(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	chatID := message.Chat.ID
+	lang := langOf(message)
+
+	retainedUploads.Lock()
+	retained, ok := retainedUploads.byChat[chatID]
+	retainedUploads.Unlock()
+	if !ok || !takeRetainedUpload(chatID, retained) {
+		sendText(bot, chatID, tr(lang, "retry.none"))
+		return
+	}
+	defer flushUploadCache()
+
+	for i, upload := range retained.uploads {
+		send := sendFile
+		if upload.document {
+			send = sendDocument
+		}
+		if err := send(bot, upload.path, chatID, upload.meta); err != nil {
+			log.Println("Error retrying upload:", err)
+			retainUpload(chatID, retained.dir, retained.uploads[i:])
+			sendText(bot, chatID, tr(lang, "retry.failed", err))
+			return
+		}
+		if upload.meta.Parts > 1 {
+			loadSentParts(retained.dir, upload.meta).mark(upload.meta.Part)
+		}
+	}
+	removeJobDir(retained.dir)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const maxFilenameBytes = 150
+
+var windowsReservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// isInvisibleRune covers control characters and the bidi and zero-width
+// formatting characters that can make a title display differently from what
+// it contains.
+func isInvisibleRune(r rune) bool {
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r) || r == utf8.RuneError
+}
+
+// sanitizeTitle cleans a title for captions and tags: it drops invisible
+// characters and collapses whitespace, but keeps emoji and punctuation.
+func sanitizeTitle(title string) string {
+	title = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case isInvisibleRune(r):
+			return -1
+		}
+		return r
+	}, title)
+	return strings.Join(strings.Fields(title), " ")
+}
+
+// sanitizeFilename turns a title into a file name that is safe on every
+// filesystem and in ffmpeg arguments. The result has no extension and is at
+// most maxFilenameBytes long.
+func sanitizeFilename(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsSpace(r):
+			return ' '
+		case isInvisibleRune(r):
+			return -1
+		case unicode.Is(unicode.So, r), unicode.Is(unicode.Sk, r), unicode.Is(unicode.Variation_Selector, r):
+			// Emoji and other pictographs.
+			return -1
+		case strings.ContainsRune(`/\:*?"<>|%`, r):
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Join(strings.Fields(name), " ")
+	name = truncateBytes(name, maxFilenameBytes)
+	name = strings.Trim(name, ". ")
+
+	if name == "" {
+		return "audio"
+	}
+	stem, _, _ := strings.Cut(name, ".")
+	if windowsReservedNames[strings.ToUpper(strings.TrimSpace(stem))] {
+		name = "_" + name
+	}
+	return name
+}
+
+// truncateBytes cuts s to at most limit bytes without splitting a rune.
+func truncateBytes(s string, limit int) string {
+	if len(s) <= limit {
+		return s
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(s[cut]) {
+		cut--
+	}
+	return s[:cut]
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSanitizeFilename(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"plain", "Artist - Song", "Artist - Song"},
+		{"slashes", "AC/DC - Back in Black", "AC_DC - Back in Black"},
+		{"backslashes", `C:\Music\song`, "C__Music_song"},
+		{"shell and ffmpeg characters", `a*b?c"d<e>f|g%h`, "a_b_c_d_e_f_g_h"},
+		{"control characters", "Song\x00Na\x07me\x1b", "SongName"},
+		{"zero-width and bidi", "Son\u200bg\u202e Name", "Song Name"},
+		{"newlines and tabs", "Line one\nLine\ttwo", "Line one Line two"},
+		{"collapsed whitespace", "  lots    of \u00a0 space  ", "lots of space"},
+		{"leading dots", "...hidden", "hidden"},
+		{"dot only", ".", "audio"},
+		{"trailing dots and spaces", "Title. . ", "Title"},
+		{"emoji", "Song 🎵 Title", "Song Title"},
+		{"non-Latin kept", "Песня — Название", "Песня — Название"},
+		{"empty", "", "audio"},
+		{"only invisible", "\u200b\x00", "audio"},
+		{"reserved name", "CON", "_CON"},
+		{"reserved name lowercase", "nul", "_nul"},
+		{"reserved name with extension", "NUL.txt", "_NUL.txt"},
+		{"reserved port", "com1.mp3", "_com1.mp3"},
+		{"reserved prefix only", "CONCERT", "CONCERT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizeFilename(tt.in); got != tt.want {
+				t.Errorf("sanitizeFilename(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSanitizeFilenameTruncatesOnRuneBoundary(t *testing.T) {
+	// Three-byte runes don't line up with the limit, so a naive cut would
+	// split one.
+	got := sanitizeFilename(strings.Repeat("世", 100))
+	if len(got) > maxFilenameBytes {
+		t.Fatalf("got %d bytes, want at most %d", len(got), maxFilenameBytes)
+	}
+	if !utf8.ValidString(got) {
+		t.Fatalf("truncated name %q is not valid UTF-8", got)
+	}
+	if want := strings.Repeat("世", maxFilenameBytes/3); got != want {
+		t.Fatalf("got %d runes, want %d", utf8.RuneCountInString(got), maxFilenameBytes/3)
+	}
+
+	got = sanitizeFilename("a" + strings.Repeat("ü", 100))
+	if len(got) > maxFilenameBytes || !utf8.ValidString(got) {
+		t.Fatalf("got %q (%d bytes), want valid UTF-8 within %d bytes", got, len(got), maxFilenameBytes)
+	}
+}
+
+func TestSanitizeFilenameTrimsAfterTruncating(t *testing.T) {
+	// Cutting at the limit can leave a trailing space, which would then be
+	// part of the file name.
+	in := strings.Repeat("a", maxFilenameBytes-1) + " tail"
+	got := sanitizeFilename(in)
+	if want := strings.Repeat("a", maxFilenameBytes-1); got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestTruncateBytes(t *testing.T) {
+	tests := []struct {
+		in    string
+		limit int
+		want  string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 3, "hel"},
+		{"héllo", 2, "h"},
+		{"héllo", 3, "hé"},
+		{"日本", 4, "日"},
+		{"日本", 2, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncateBytes(tt.in, tt.limit); got != tt.want {
+			t.Errorf("truncateBytes(%q, %d) = %q, want %q", tt.in, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"  Song \n  Title\t", "Song Title"},
+		{"Song\x00\u200b Title", "Song Title"},
+		{"AC/DC: Live?", "AC/DC: Live?"},
+		{"Song 🎵", "Song 🎵"},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeTitle(tt.in); got != tt.want {
+			t.Errorf("sanitizeTitle(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
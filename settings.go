@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func handleSettings(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	msg := tgbotapi.NewMessage(message.Chat.ID, settingsText(message.Chat.ID, langOf(message)))
+	msg.ReplyMarkup = settingsKeyboard(message.Chat.ID, langOf(message))
+	if _, err := sendMessage(bot, msg); err != nil {
+		log.Println("Error sending settings:", err)
+	}
+}
+
+// settingsText lists every per-chat preference, marking the ones that still
+// follow the bot's defaults.
+func settingsText(chatID int64, lang string) string {
+	p := prefs.get(chatID)
+	sampleRate, channels := audioOptionsFor(chatID)
+	aac, aacQuality := aacFor(chatID)
+
+	quality := tr(lang, "settings.auto")
+	if p.Bitrate != 0 {
+		quality = tr(lang, "settings.kbps", p.Bitrate)
+	}
+	subtitleLang := p.SubtitleLang
+	if subtitleLang == "" {
+		subtitleLang = tr(lang, "settings.video_language")
+	}
+
+	language := tr(lang, "lang.auto")
+	if p.Lang != "" {
+		language = tr(p.Lang, "lang.name")
+	}
+
+	lines := []string{
+		tr(lang, "settings.header"),
+		"",
+		settingLine(lang, "settings.quality", quality, p.Bitrate == 0),
+		settingLine(lang, "settings.sample_rate", describeSampleRate(lang, sampleRate), p.SampleRate == 0),
+		settingLine(lang, "settings.channels", describeChannels(lang, channels), p.Channels == 0),
+		settingLine(lang, "settings.zip", onOff(lang, p.Zip), !p.Zip),
+		settingLine(lang, "settings.autoplaylist", onOff(lang, autoPlaylistFor(chatID)), p.AutoPlaylist == nil),
+		settingLine(lang, "settings.trimsilence", onOff(lang, trimSilenceFor(chatID)), p.TrimSilence == nil),
+		settingLine(lang, "settings.fade", onOff(lang, fadeFor(chatID)), p.Fade == nil),
+		settingLine(lang, "settings.aac", describeAAC(lang, aac, aacQuality), p.AAC == nil),
+		settingLine(lang, "settings.speed", describeSpeed(lang, speedFor(chatID)), p.Speed == nil),
+		settingLine(lang, "settings.subtitle_lang", subtitleLang, p.SubtitleLang == ""),
+		settingLine(lang, "settings.lang", language, p.Lang == ""),
+	}
+	return strings.Join(lines, "\n")
+}
+
+func settingLine(lang string, key string, value string, isDefault bool) string {
+	if isDefault {
+		return tr(lang, "settings.line_default", tr(lang, key), value)
+	}
+	return fmt.Sprintf("%s: %s", tr(lang, key), value)
+}
+
+func onOff(lang string, enabled bool) string {
+	if enabled {
+		return tr(lang, "settings.on")
+	}
+	return tr(lang, "settings.off")
+}
+
+func settingsKeyboard(chatID int64, lang string) tgbotapi.InlineKeyboardMarkup {
+	p := prefs.get(chatID)
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr(lang, "settings.button_quality", nextQualityLabel(lang, p.Bitrate)), "settings:quality"),
+			tgbotapi.NewInlineKeyboardButtonData(tr(lang, "settings.button_zip", onOff(lang, !p.Zip)), "settings:zip"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData(tr(lang, "settings.button_autoplaylist", onOff(lang, !autoPlaylistFor(chatID))), "settings:autoplaylist"),
+			tgbotapi.NewInlineKeyboardButtonData(tr(lang, "settings.button_trimsilence", onOff(lang, !trimSilenceFor(chatID))), "settings:trimsilence"),
+		),
+	)
+}
+
+// nextQuality cycles auto → highest standard bitrate → ... → lowest → auto.
+func nextQuality(kbps int) int {
+	if kbps == 0 {
+		return standardBitrates[0]
+	}
+	for i, standard := range standardBitrates {
+		if standard == kbps && i+1 < len(standardBitrates) {
+			return standardBitrates[i+1]
+		}
+	}
+	return 0
+}
+
+func nextQualityLabel(lang string, kbps int) string {
+	if next := nextQuality(kbps); next != 0 {
+		return tr(lang, "settings.kbps", next)
+	}
+	return tr(lang, "settings.auto")
+}
+
+func handleSettingsCallback(bot *tgbotapi.BotAPI, query *tgbotapi.CallbackQuery, arg string) {
+	if query.Message == nil {
+		answerCallback(bot, query, "")
+		return
+	}
+	chatID := query.Message.Chat.ID
+	if !canChangeChatSettings(bot, query.Message.Chat, query.From) {
+		answerCallback(bot, query, tr(callbackLanguage(query), "settings.group_admin_only"))
+		return
+	}
+
+	// Resolved before update, which holds the prefs lock.
+	autoPlaylist := !autoPlaylistFor(chatID)
+	trim := !trimSilenceFor(chatID)
+	err := prefs.update(chatID, func(p *chatPrefs) {
+		switch arg {
+		case "quality":
+			p.Bitrate = nextQuality(p.Bitrate)
+		case "zip":
+			p.Zip = !p.Zip
+		case "autoplaylist":
+			p.AutoPlaylist = &autoPlaylist
+		case "trimsilence":
+			p.TrimSilence = &trim
+		}
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		answerCallback(bot, query, tr(callbackLanguage(query), "prefs.save_failed"))
+		return
+	}
+	answerCallback(bot, query, "")
+
+	edit := tgbotapi.NewEditMessageTextAndMarkup(chatID, query.Message.MessageID, settingsText(chatID, callbackLanguage(query)), settingsKeyboard(chatID, callbackLanguage(query)))
+	if _, err := sendMessage(bot, edit); err != nil {
+		log.Println("Error updating settings:", err)
+	}
+}
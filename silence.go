@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const silenceSearchWindow = 30.0 // seconds either side of the ideal cut
+
+const (
+	defaultTrimSilenceThresholdDB = -50
+	defaultTrimSilenceKeepSeconds = 0.5
+)
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[\d.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: ([\d.]+)`)
+)
+
+// detectSilences returns the midpoint of every silent stretch in the file,
+// in seconds from the start.
+func detectSilences(filePath string, job *activeJob) ([]float64, error) {
+	cmd := exec.Command("ffmpeg", "-i", filePath, "-af", "silencedetect=noise=-35dB:d=0.5", "-f", "null", "-")
+	output, err := job.run(cmd)
+	if errors.Is(err, errCancelled) {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Error detecting silence with ffmpeg: %s\n%s", err, string(output))
+		return nil, err
+	}
+
+	var silences []float64
+	start := -1.0
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+			start, _ = strconv.ParseFloat(match[1], 64)
+			continue
+		}
+		if match := silenceEndPattern.FindStringSubmatch(line); match != nil && start >= 0 {
+			end, _ := strconv.ParseFloat(match[1], 64)
+			silences = append(silences, (start+end)/2)
+			start = -1
+		}
+	}
+
+	return silences, nil
+}
+
+// silenceCutPoints places a cut near every multiple of segmentTime, moving it
+// to the closest silence within the search window. Cuts never move later than
+// maxSegment past the previous one, so parts stay under the size limit.
+func silenceCutPoints(silences []float64, duration float64, segmentTime float64, maxSegment float64) []float64 {
+	var cuts []float64
+	prev := 0.0
+	for {
+		ideal := prev + segmentTime
+		if ideal >= duration {
+			break
+		}
+
+		low := ideal - silenceSearchWindow
+		high := ideal + silenceSearchWindow
+		if high > prev+maxSegment {
+			high = prev + maxSegment
+		}
+
+		cut := ideal
+		bestDistance := -1.0
+		for _, silence := range silences {
+			if silence <= prev || silence < low || silence > high {
+				continue
+			}
+			distance := silence - ideal
+			if distance < 0 {
+				distance = -distance
+			}
+			if bestDistance < 0 || distance < bestDistance {
+				cut = silence
+				bestDistance = distance
+			}
+		}
+
+		cuts = append(cuts, cut)
+		prev = cut
+	}
+	return cuts
+}
+
+func formatSegmentTimes(cuts []float64) string {
+	times := make([]string, len(cuts))
+	for i, cut := range cuts {
+		times[i] = fmt.Sprintf("%.3f", cut)
+	}
+	return strings.Join(times, ",")
+}
+
+func trimSilenceFor(chatID int64) bool {
+	if enabled := prefs.get(chatID).TrimSilence; enabled != nil {
+		return *enabled
+	}
+	return conf.TrimSilence
+}
+
+// trimSilence cuts leading and trailing silence in place. silenceremove only
+// works from the start, so the tail is handled by trimming the reversed audio.
+func trimSilence(filePath string, kbps int, job *activeJob) error {
+	threshold := *conf.TrimSilenceThresholdDB
+	keep := *conf.TrimSilenceKeepSeconds
+	trim := fmt.Sprintf("silenceremove=start_periods=1:start_threshold=%ddB:start_silence=%g", threshold, keep)
+	filter := strings.Join([]string{trim, "areverse", trim, "areverse"}, ",")
+
+	ext := filepath.Ext(filePath)
+	outputPath := strings.TrimSuffix(filePath, ext) + ".trim" + ext
+	output, err := job.run(exec.Command("ffmpeg", "-i", filePath, "-vn", "-af", filter, "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", kbps), outputPath))
+	if err != nil {
+		log.Printf("Error trimming silence with ffmpeg: %s\n%s", err, string(output))
+		os.Remove(outputPath)
+		return fmt.Errorf("could not trim silence: %v", err)
+	}
+
+	return os.Rename(outputPath, filePath)
+}
+
+func handleTrimSilenceSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if trimSilenceFor(message.Chat.ID) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "trimsilence.current_on"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "trimsilence.current_off"))
+		}
+		return
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "trimsilence.usage"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.TrimSilence = &enabled
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if enabled {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "trimsilence.on"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "trimsilence.off"))
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	neturl "net/url"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// socialSites are the hosts accepted besides YouTube when social-sites is on.
+// A link must contain one of paths to count as a single video, except on
+// shortHosts, whose links are opaque share codes.
+var socialSites = []struct {
+	name       string
+	hosts      []string
+	shortHosts []string
+	paths      []string
+}{
+	{"Instagram", []string{"instagram.com"}, nil, []string{"/p/", "/reel/", "/reels/", "/tv/"}},
+	{"TikTok", []string{"tiktok.com"}, []string{"vm.tiktok.com", "vt.tiktok.com"}, []string{"/video/", "/t/"}},
+	{"X", []string{"x.com", "twitter.com"}, nil, []string{"/status/"}},
+}
+
+// isSupportedURL reports whether url is a video link the bot downloads.
+func isSupportedURL(url string) bool {
+	return isValidYouTubeURL(url) || (conf.SocialSites && isSocialURL(url))
+}
+
+func isSocialURL(url string) bool {
+	parsed, err := neturl.Parse(url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") {
+		return false
+	}
+	host := strings.ToLower(parsed.Hostname())
+
+	for _, site := range socialSites {
+		for _, shortHost := range site.shortHosts {
+			if host == shortHost {
+				return len(strings.Trim(parsed.Path, "/")) > 0
+			}
+		}
+		for _, siteHost := range site.hosts {
+			if host != siteHost && !strings.HasSuffix(host, "."+siteHost) {
+				continue
+			}
+			for _, path := range site.paths {
+				if strings.Contains(parsed.Path, path) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return false
+}
+
+// supportedSitesText names the sites links are accepted from, for replies to
+// links that aren't.
+func supportedSitesText(lang string) string {
+	if !conf.SocialSites {
+		return "YouTube"
+	}
+	names := []string{"YouTube"}
+	for _, site := range socialSites {
+		names = append(names, site.name)
+	}
+	return tr(lang, "sites.either", strings.Join(names[:len(names)-1], ", "), names[len(names)-1])
+}
+
+func handleSites(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	lines := []string{
+		tr(langOf(message), "sites.header"),
+		tr(langOf(message), "sites.youtube"),
+	}
+	if conf.SocialSites {
+		for _, site := range socialSites {
+			hosts := append(append([]string{}, site.hosts...), site.shortHosts...)
+			lines = append(lines, fmt.Sprintf("- %s (%s)", site.name, strings.Join(hosts, ", ")))
+		}
+	}
+	lines = append(lines, "", tr(langOf(message), "sites.others"))
+	sendText(bot, message.Chat.ID, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// A single atempo filter only takes 0.5 to 2, so speeds past that are chained
+// from several.
+const (
+	minSpeed = 0.25
+	maxSpeed = 4.0
+)
+
+func isValidSpeed(speed float64) bool {
+	return speed == 0 || (speed >= minSpeed && speed <= maxSpeed)
+}
+
+// speedFor is the playback speed downloads in chatID are sped up or slowed
+// down to; 1 leaves them as they are.
+func speedFor(chatID int64) float64 {
+	speed := conf.Speed
+	if p := prefs.get(chatID); p.Speed != nil {
+		speed = *p.Speed
+	}
+	if speed == 0 {
+		return 1
+	}
+	return speed
+}
+
+// atempoFilter chains atempo filters that together change the tempo by speed.
+func atempoFilter(speed float64) string {
+	var filters []string
+	for speed > 2 {
+		filters = append(filters, "atempo=2")
+		speed /= 2
+	}
+	for speed < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		speed /= 0.5
+	}
+	filters = append(filters, fmt.Sprintf("atempo=%g", speed))
+	return strings.Join(filters, ",")
+}
+
+// changeSpeed re-encodes filePath in place at speed times the original tempo,
+// keeping the pitch.
+func changeSpeed(filePath string, kbps int, speed float64, job *activeJob) error {
+	ext := filepath.Ext(filePath)
+	outputPath := strings.TrimSuffix(filePath, ext) + ".speed" + ext
+	output, err := job.run(exec.Command("ffmpeg", "-i", filePath, "-vn", "-af", atempoFilter(speed), "-c:a", encoderFor(filePath), "-b:a", fmt.Sprintf("%dk", kbps), outputPath))
+	if err != nil {
+		log.Printf("Error changing speed with ffmpeg: %s\n%s", err, string(output))
+		os.Remove(outputPath)
+		return fmt.Errorf("could not change speed: %v", err)
+	}
+
+	return os.Rename(outputPath, filePath)
+}
+
+func describeSpeed(lang string, speed float64) string {
+	if speed == 1 {
+		return tr(lang, "speed.normal")
+	}
+	return tr(lang, "speed.value", speed)
+}
+
+func handleSpeedSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	arg := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(args), "x"))
+
+	if arg == "" {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "speed.current", describeSpeed(langOf(message), speedFor(message.Chat.ID)))+"\n\n"+tr(langOf(message), "speed.usage", minSpeed, maxSpeed))
+		return
+	}
+
+	speed := 1.0
+	if arg != "off" {
+		parsed, err := strconv.ParseFloat(strings.ReplaceAll(arg, ",", "."), 64)
+		if err != nil || parsed == 0 || !isValidSpeed(parsed) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "speed.invalid", minSpeed, maxSpeed))
+			return
+		}
+		speed = parsed
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.Speed = &speed
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	sendText(bot, message.Chat.ID, tr(langOf(message), "speed.current", describeSpeed(langOf(message), speed)))
+}
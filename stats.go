@@ -0,0 +1,111 @@
+package main
+
+import (
+	"log"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+type userStats struct {
+	Downloads int            `json:"downloads"`
+	Bytes     int64          `json:"bytes"`
+	Hosts     map[string]int `json:"hosts,omitempty"`
+}
+
+func (s *prefsStore) addStats(userID int64, host string, bytes int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats, ok := s.data.Stats[userID]
+	if !ok {
+		stats = &userStats{}
+		s.data.Stats[userID] = stats
+	}
+	if stats.Hosts == nil {
+		stats.Hosts = make(map[string]int)
+	}
+	stats.Downloads++
+	stats.Bytes += bytes
+	if host != "" {
+		stats.Hosts[host]++
+	}
+	return s.save()
+}
+
+func (s *prefsStore) stats(userID int64) userStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if stats, ok := s.data.Stats[userID]; ok {
+		return *stats
+	}
+	return userStats{}
+}
+
+// statsHost names the site of url the way users think of it, so that
+// youtu.be and m.youtube.com links count as youtube.com.
+func statsHost(url string) string {
+	parsed, err := neturl.Parse(url)
+	if err != nil {
+		return ""
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, prefix := range []string{"www.", "m.", "mobile.", "music."} {
+		host = strings.TrimPrefix(host, prefix)
+	}
+	if host == "youtu.be" {
+		host = "youtube.com"
+	}
+	return host
+}
+
+func recordStats(message *tgbotapi.Message, url string, bytes int64) {
+	if message.From == nil {
+		return
+	}
+	if err := prefs.addStats(message.From.ID, statsHost(url), bytes); err != nil {
+		log.Println("Error saving stats:", err)
+	}
+}
+
+func handleMyStats(bot *tgbotapi.BotAPI, message *tgbotapi.Message) {
+	if message.From == nil {
+		return
+	}
+	stats := prefs.stats(message.From.ID)
+	if stats.Downloads == 0 {
+		replyText(bot, message, tr(langOf(message), "stats.none"))
+		return
+	}
+
+	topHost, topCount := "", 0
+	for host, count := range stats.Hosts {
+		if count > topCount || (count == topCount && host < topHost) {
+			topHost, topCount = host, count
+		}
+	}
+
+	lines := []string{
+		tr(langOf(message), "stats.header"),
+		tr(langOf(message), "stats.downloads", stats.Downloads),
+		tr(langOf(message), "stats.size", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, formatSize(stats.Bytes))),
+	}
+	if topHost != "" {
+		// Host names may contain underscores, which Markdown would take
+		// for the start of italics and then refuse the message.
+		lines = append(lines, tr(langOf(message), "stats.top_site", tgbotapi.EscapeText(tgbotapi.ModeMarkdown, topHost), topCount))
+	}
+	if conf.DailyQuota > 0 && !isAdmin(message.From.ID) {
+		used := prefs.dailyUsage(message.From.ID, time.Now().UTC().Format("2006-01-02"))
+		lines = append(lines, tr(langOf(message), "stats.today", used, conf.DailyQuota))
+	}
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, strings.Join(lines, "\n"))
+	msg.ParseMode = tgbotapi.ModeMarkdown
+	msg.ReplyToMessageID = replyTarget(message)
+	if _, err := sendMessage(bot, msg); err != nil {
+		log.Println("Error sending stats:", err)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+var langCodePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+func isValidLangCode(code string) bool {
+	return len(code) <= 35 && langCodePattern.MatchString(code)
+}
+
+func handleSetLang(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	lang := strings.TrimSpace(args)
+
+	if lang == "" {
+		current := prefs.get(message.Chat.ID).SubtitleLang
+		if current == "" {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "setlang.none"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "setlang.current", current))
+		}
+		return
+	}
+
+	if !isValidLangCode(lang) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "setlang.invalid"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.SubtitleLang = lang
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	sendText(bot, message.Chat.ID, tr(langOf(message), "setlang.set", lang))
+}
+
+func handleSubs(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	fields := strings.Fields(args)
+	if len(fields) == 0 || !isValidYouTubeURL(fields[0]) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "subs.usage"))
+		return
+	}
+	url := fields[0]
+
+	lang := prefs.get(message.Chat.ID).SubtitleLang
+	stored := true
+	if len(fields) > 1 {
+		lang = fields[1]
+		stored = false
+		if !isValidLangCode(lang) {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "setlang.invalid"))
+			return
+		}
+	}
+
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), err))
+		return
+	}
+	if lang == "" {
+		sendText(bot, message.Chat.ID, subtitleLanguagesText(langOf(message), info)+"\n\n"+tr(langOf(message), "subs.hint"))
+		return
+	}
+
+	// A language asked for with the command has to be there; the stored
+	// preference falls back to the video's own language and then English.
+	candidates := []string{lang}
+	if stored {
+		candidates = append(candidates, info.Language, "en")
+	}
+
+	found, auto, ok := pickSubtitles(info, candidates)
+	if !ok {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "subs.missing", lang)+"\n\n"+subtitleLanguagesText(langOf(message), info))
+		return
+	}
+	if found != lang {
+		log.Printf("No %s subtitles for %s, sending %s instead", lang, url, found)
+	}
+	lang = found
+
+	dir, err := newJobDir(message.Chat.ID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+
+	subsPath, err := downloadSubtitles(url, lang, dir, auto)
+	if err != nil {
+		log.Println("Error downloading subtitles:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "subs.download_failed", err))
+		return
+	}
+
+	doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FilePath(subsPath))
+	if auto {
+		doc.Caption = tr(langOf(message), "subs.caption_auto", lang)
+	} else {
+		doc.Caption = tr(langOf(message), "subs.caption", lang)
+	}
+	if _, err := sendMessage(bot, doc); err != nil {
+		log.Println("Error sending subtitles:", err)
+	}
+}
+
+// pickSubtitles returns the first of langs the video has captions in, and
+// whether those are automatic; captions uploaded by the creator beat the
+// automatic ones. Empty entries are skipped.
+func pickSubtitles(info *videoInfo, langs []string) (string, bool, bool) {
+	for _, lang := range langs {
+		if lang == "" {
+			continue
+		}
+		if _, ok := info.Subtitles[lang]; ok {
+			return lang, false, true
+		}
+		if _, ok := info.AutomaticCaptions[lang]; ok {
+			return lang, true, true
+		}
+	}
+	return "", false, false
+}
+
+// subtitleLanguagesText lists the video's subtitle languages, uploaded and
+// auto-generated ones separately.
+func subtitleLanguagesText(lang string, info *videoInfo) string {
+	uploaded := sortedKeys(info.Subtitles)
+	automatic := sortedKeys(info.AutomaticCaptions)
+	if len(uploaded) == 0 && len(automatic) == 0 {
+		return tr(lang, "subs.none")
+	}
+
+	var lines []string
+	if len(uploaded) > 0 {
+		lines = append(lines, tr(lang, "subs.uploaded", strings.Join(uploaded, ", ")))
+	}
+	if len(automatic) > 0 {
+		lines = append(lines, tr(lang, "subs.automatic", strings.Join(automatic, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		// live_chat isn't a language, it's the replay of a stream's chat.
+		if key != "live_chat" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func downloadSubtitles(url string, lang string, dir string, auto bool) (string, error) {
+	base := filepath.Join(dir, "subs")
+
+	writeSubs := "--write-subs"
+	if auto {
+		writeSubs = "--write-auto-subs"
+	}
+	cmd := ytDlpCommand(
+		"--skip-download",
+		writeSubs,
+		"--sub-langs", lang,
+		"--convert-subs", "srt",
+		"--no-playlist",
+		"-o", base+".%(ext)s",
+		"--", url,
+	)
+
+	output, err := cmd.CombinedOutput()
+
+	log.Printf("yt-dlp output: %s", output)
+
+	if err != nil {
+		log.Println("Error executing yt-dlp:", err)
+		return "", err
+	}
+
+	matches, _ := filepath.Glob(globEscape(base) + "*.srt")
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no subtitles available in %s", lang)
+	}
+
+	return matches[0], nil
+}
@@ -0,0 +1,32 @@
+package main
+
+import "testing"
+
+func TestPickSubtitles(t *testing.T) {
+	info := &videoInfo{
+		Language:          "fr",
+		Subtitles:         map[string][]subtitleTrack{"fr": nil},
+		AutomaticCaptions: map[string][]subtitleTrack{"en": nil, "fr": nil},
+	}
+	tests := []struct {
+		name  string
+		langs []string
+		want  string
+		auto  bool
+		ok    bool
+	}{
+		{"uploaded beats automatic", []string{"fr"}, "fr", false, true},
+		{"automatic only", []string{"en"}, "en", true, true},
+		{"falls back to the video's language", []string{"de", info.Language, "en"}, "fr", false, true},
+		{"falls back to English", []string{"de", "", "en"}, "en", true, true},
+		{"nothing", []string{"de"}, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, auto, ok := pickSubtitles(info, tt.langs)
+			if got != tt.want || auto != tt.auto || ok != tt.ok {
+				t.Fatalf("pickSubtitles(%v) = %q, %v, %v, want %q, %v, %v", tt.langs, got, auto, ok, tt.want, tt.auto, tt.ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+func handleThumb(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	url := strings.TrimSpace(args)
+	if !isValidYouTubeURL(url) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "thumb.usage"))
+		return
+	}
+
+	dir, err := newJobDir(message.Chat.ID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+
+	thumbPath, err := downloadThumbnail(url, dir)
+	if err != nil {
+		log.Println("Error downloading thumbnail:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "thumb.download_failed", err))
+		return
+	}
+
+	photo := tgbotapi.NewPhoto(message.Chat.ID, tgbotapi.FilePath(thumbPath))
+	if _, err := sendMessage(bot, photo); err != nil {
+		log.Println("Error sending thumbnail:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "thumb.send_failed", err))
+	}
+}
+
+func downloadThumbnail(url string, dir string) (string, error) {
+	base := filepath.Join(dir, "thumb")
+
+	cmd := ytDlpCommand(
+		"--skip-download",
+		"--write-all-thumbnails",
+		"--convert-thumbnails", "jpg",
+		"--no-playlist",
+		"-o", base+".%(ext)s",
+		"--", url,
+	)
+
+	output, err := cmd.CombinedOutput()
+
+	log.Printf("yt-dlp output: %s", output)
+
+	if err != nil {
+		log.Println("Error executing yt-dlp:", err)
+		return "", err
+	}
+
+	matches, _ := filepath.Glob(globEscape(base) + "*.jpg")
+
+	largest := ""
+	var largestSize int64
+	for _, match := range matches {
+		fileInfo, err := os.Stat(match)
+		if err != nil {
+			continue
+		}
+		if fileInfo.Size() > largestSize {
+			largest = match
+			largestSize = fileInfo.Size()
+		}
+	}
+
+	if largest == "" {
+		return "", fmt.Errorf("no thumbnail available")
+	}
+
+	return largest, nil
+}
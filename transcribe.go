@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	defaultTranscribeTimeout = 60 * time.Minute
+	transcribeProgressEvery  = 30 * time.Second
+)
+
+var whisperProgressPattern = regexp.MustCompile(`progress\s*=\s*(\d+)%`)
+
+func handleTranscribe(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	if conf.WhisperPath == "" {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "transcribe.disabled"))
+		return
+	}
+
+	url := strings.TrimSpace(args)
+	if !isValidYouTubeURL(url) {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "transcribe.usage"))
+		return
+	}
+
+	// Look the video up first: a live stream would otherwise keep yt-dlp
+	// recording forever, outside the transcription timeout.
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), err))
+		return
+	}
+	if info.IsLive {
+		sendText(bot, message.Chat.ID, userErrorMessage(langOf(message), errStillLive))
+		return
+	}
+	if reason := checkDurationLimit(langOf(message), info); reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+
+	if reason := checkDiskSpace(bot, langOf(message), info, bitrateKBps); reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+	// The mp3 is sent along with the transcript, so it counts as a download.
+	reason, refund := takeQuota(message)
+	if reason != "" {
+		sendText(bot, message.Chat.ID, reason)
+		return
+	}
+
+	job := newQueuedJob(message, url, info)
+	reportQueuePosition(bot, message, job, downloads.enqueue(job))
+	if !downloads.wait(job) {
+		refund()
+		return
+	}
+	defer downloads.release(job)
+
+	active := startActiveJob(message)
+	defer active.finish()
+	keyboard := cancelKeyboard(active)
+
+	msg := tgbotapi.NewMessage(message.Chat.ID, tr(langOf(message), "status.starting"))
+	msg.ReplyToMessageID = replyTarget(message)
+	msg.ReplyMarkup = keyboard
+	status, err := sendMessage(bot, msg)
+	if err != nil {
+		log.Println("Error sending message:", err)
+	}
+
+	// update edits the status message; a nil markup takes the Cancel button
+	// off once the job is past cancelling.
+	update := func(text string, markup *tgbotapi.InlineKeyboardMarkup) {
+		if status.MessageID == 0 {
+			return
+		}
+		edit := tgbotapi.NewEditMessageText(message.Chat.ID, status.MessageID, text)
+		edit.ReplyMarkup = markup
+		if _, err := sendMessage(bot, edit); err != nil {
+			log.Println("Error updating status:", err)
+		}
+	}
+	fail := func(text string) {
+		if status.MessageID == 0 {
+			sendText(bot, message.Chat.ID, text)
+			return
+		}
+		update(text, nil)
+	}
+	cancelled := func() {
+		log.Printf("Transcription of %s was cancelled", url)
+		fail(tr(langOf(message), "download.cancelled"))
+	}
+
+	dir, err := newJobDir(message.Chat.ID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		fail(tr(langOf(message), "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+	// whisper.cpp works from a 16 kHz mono wav next to the mp3.
+	if !diskUsage.reserve(dir, requiredDiskSpace(info, bitrateKBps)+estimateSize(info.Duration, 256)) {
+		log.Printf("Rejecting transcription of %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+		fail(tr(langOf(message), "download.no_disk_space"))
+		return
+	}
+
+	opts := downloadOptions{Job: active, Slot: job}
+	mp3FilePath, err := downloadMp3(url, dir, bitrateKBps, opts)
+	if errors.Is(err, errCancelled) {
+		cancelled()
+		return
+	}
+	if err != nil {
+		log.Println("Error downloading mp3:", err)
+		fail(userErrorMessage(langOf(message), err))
+		return
+	}
+
+	timeout := defaultTranscribeTimeout
+	if conf.TranscribeTimeoutMinutes > 0 {
+		timeout = time.Duration(conf.TranscribeTimeoutMinutes) * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	update(tr(langOf(message), "transcribe.started"), keyboard)
+	transcriptPath, err := transcribeFile(ctx, langOf(message), mp3FilePath, active, func(text string) {
+		update(text, keyboard)
+	})
+	if errors.Is(err, errCancelled) || !active.commit() {
+		cancelled()
+		return
+	}
+	if err != nil {
+		log.Println("Error transcribing:", err)
+		if ctx.Err() == context.DeadlineExceeded {
+			fail(trn(langOf(message), "transcribe.timeout", int(timeout.Minutes())))
+		} else {
+			fail(tr(langOf(message), "transcribe.failed", err))
+		}
+	} else {
+		update(tr(langOf(message), "transcribe.finished"), nil)
+		doc := tgbotapi.NewDocument(message.Chat.ID, tgbotapi.FilePath(transcriptPath))
+		if _, err := sendMessage(bot, doc); err != nil {
+			log.Println("Error sending transcript:", err)
+			sendText(bot, message.Chat.ID, tr(langOf(message), "transcribe.send_failed", err))
+		}
+		removeTempFile(transcriptPath)
+	}
+
+	err = checkAndSendFile(mp3FilePath, message.Chat.ID, bot, newTrackMeta(url, info, bitrateKBps, langOf(message)), opts)
+	if err != nil {
+		log.Println("Error sending mp3:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "download.send_failed", err))
+	}
+}
+
+// transcribeFile runs ffmpeg and whisper as part of job, so cancelling the job
+// kills them.
+func transcribeFile(ctx context.Context, lang string, mp3FilePath string, job *activeJob, progress func(string)) (string, error) {
+	base := strings.TrimSuffix(mp3FilePath, ".mp3")
+	wavPath := base + ".wav"
+	defer removeTempFile(wavPath)
+
+	// whisper.cpp only accepts 16 kHz mono PCM.
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", mp3FilePath, "-ar", "16000", "-ac", "1", "-c:a", "pcm_s16le", wavPath)
+	output, err := job.run(cmd)
+	if errors.Is(err, errCancelled) {
+		return "", err
+	}
+	if err != nil {
+		log.Printf("Error converting to wav with ffmpeg: %s\n%s", err, string(output))
+		return "", err
+	}
+
+	args := []string{"-f", wavPath, "-otxt", "-of", base, "-pp"}
+	if conf.WhisperModel != "" {
+		args = append(args, "-m", conf.WhisperModel)
+	}
+	cmd = exec.CommandContext(ctx, conf.WhisperPath, args...)
+
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return "", err
+	}
+	if err := job.start(cmd); err != nil {
+		if errors.Is(err, errCancelled) {
+			return "", err
+		}
+		return "", fmt.Errorf("could not start whisper: %v", err)
+	}
+	defer job.done(cmd)
+
+	started := time.Now()
+	lastUpdate := started
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		match := whisperProgressPattern.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		percent, _ := strconv.Atoi(match[1])
+		if percent <= 0 || percent >= 100 || time.Since(lastUpdate) < transcribeProgressEvery {
+			continue
+		}
+		lastUpdate = time.Now()
+
+		elapsed := time.Since(started)
+		remaining := elapsed * time.Duration(100-percent) / time.Duration(percent)
+		progress(tr(lang, "transcribe.progress", percent, int(remaining.Minutes())+1))
+	}
+	// A line too long to scan stops the loop; whisper would block writing
+	// the rest if nothing read it.
+	if err := scanner.Err(); err != nil {
+		log.Println("Error reading whisper output:", err)
+	}
+	io.Copy(io.Discard, stderr)
+
+	err = cmd.Wait()
+	if job.isCancelled() {
+		return "", errCancelled
+	}
+	if err != nil {
+		return "", fmt.Errorf("whisper failed: %v", err)
+	}
+
+	transcriptPath := base + ".txt"
+	if _, err := os.Stat(transcriptPath); err != nil {
+		return "", fmt.Errorf("whisper produced no transcript: %v", err)
+	}
+
+	return transcriptPath, nil
+}
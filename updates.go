@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	minUpdatesBackoff = time.Second
+	maxUpdatesBackoff = 2 * time.Minute
+)
+
+// lastPoll is when GetUpdates last succeeded, in Unix nanoseconds, for the
+// liveness probe.
+var lastPoll atomic.Int64
+
+// sinceLastPoll is zero until polling starts, while the bot starts up.
+func sinceLastPoll() time.Duration {
+	last := lastPoll.Load()
+	if last == 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, last))
+}
+
+// pollUpdates long-polls Telegram for as long as the bot runs. A failed poll
+// is retried with exponential backoff, capped at maxUpdatesBackoff, and the
+// offset carries over, so a dropped connection neither stops the bot nor
+// loses or repeats updates.
+func pollUpdates(bot *tgbotapi.BotAPI, handle func(tgbotapi.Update)) {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = 60
+
+	// Starting to poll counts as a poll, so the bot is alive before the
+	// first long poll returns.
+	lastPoll.Store(time.Now().UnixNano())
+	var backoff time.Duration
+	for {
+		updates, err := bot.GetUpdates(u)
+		if err != nil {
+			backoff = min(max(backoff*2, minUpdatesBackoff), maxUpdatesBackoff)
+			var tgErr *tgbotapi.Error
+			if errors.As(err, &tgErr) && time.Duration(tgErr.RetryAfter)*time.Second > backoff {
+				backoff = time.Duration(tgErr.RetryAfter) * time.Second
+			}
+			log.Printf("Error getting updates, reconnecting in %s: %v", backoff, err)
+			time.Sleep(backoff)
+			continue
+		}
+		lastPoll.Store(time.Now().UnixNano())
+		if backoff > 0 {
+			log.Println("Reconnected to Telegram")
+			backoff = 0
+		}
+
+		for _, update := range updates {
+			if update.UpdateID >= u.Offset {
+				u.Offset = update.UpdateID + 1
+			}
+			handle(update)
+		}
+	}
+}
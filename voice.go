@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	maxVoiceDuration       = 10 * time.Minute
+	voiceBitrateKbps       = 48
+	maxVoiceSize     int64 = 20 * 1024 * 1024 // 20 MB
+)
+
+func handleVoice(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	chatID := message.Chat.ID
+	lang := langOf(message)
+	url := strings.TrimSpace(args)
+	if !isValidYouTubeURL(url) {
+		replyText(bot, message, tr(lang, "voice.usage"))
+		return
+	}
+
+	info, err := fetchVideoInfo(url)
+	if err != nil {
+		replyText(bot, message, userErrorMessage(lang, err))
+		return
+	}
+	if info.IsLive {
+		replyText(bot, message, userErrorMessage(lang, errStillLive))
+		return
+	}
+	if reason := checkDurationLimit(lang, info); reason != "" {
+		replyText(bot, message, reason)
+		return
+	}
+	if info.Duration <= 0 || time.Duration(info.Duration)*time.Second > maxVoiceDuration {
+		replyText(bot, message, trn(lang, "voice.too_long", int(maxVoiceDuration.Minutes())))
+		return
+	}
+
+	reason, refund := takeQuota(message)
+	if reason != "" {
+		replyText(bot, message, reason)
+		return
+	}
+
+	job := newQueuedJob(message, url, info)
+	reportQueuePosition(bot, message, job, downloads.enqueue(job))
+	if !downloads.wait(job) {
+		refund()
+		return
+	}
+	defer downloads.release(job)
+
+	active := startActiveJob(message)
+	defer active.finish()
+	defer replyWithCancel(bot, message, tr(lang, "status.starting"), active)()
+	cancelled := func() {
+		log.Printf("Voice note of %s was cancelled", url)
+		sendText(bot, chatID, tr(lang, "download.cancelled"))
+	}
+
+	if reason := checkDiskSpace(bot, lang, info, voiceBitrateKbps); reason != "" {
+		sendText(bot, chatID, reason)
+		return
+	}
+
+	dir, err := newJobDir(chatID)
+	if err != nil {
+		log.Println("Error creating job directory:", err)
+		sendText(bot, chatID, tr(lang, "download.prepare_failed", err))
+		return
+	}
+	defer removeJobDir(dir)
+	if !diskUsage.reserve(dir, requiredDiskSpace(info, voiceBitrateKbps)) {
+		log.Printf("Rejecting voice note of %s, it would go over max-disk-usage-mb of %d", url, conf.MaxDiskUsageMB)
+		sendText(bot, chatID, tr(lang, "download.no_disk_space"))
+		return
+	}
+
+	voicePath, err := downloadVoice(url, dir, active)
+	if errors.Is(err, errCancelled) {
+		cancelled()
+		return
+	}
+	if err != nil {
+		log.Println("Error downloading voice:", err)
+		sendText(bot, chatID, tr(lang, "voice.download_failed", err))
+		return
+	}
+
+	duration, err := validateVoiceFile(voicePath, active)
+	if errors.Is(err, errCancelled) || !active.commit() {
+		cancelled()
+		return
+	}
+	if err != nil {
+		log.Println("Error validating voice:", err)
+		sendText(bot, chatID, tr(lang, "voice.prepare_failed", err))
+		return
+	}
+
+	voice := tgbotapi.NewVoice(chatID, tgbotapi.FilePath(voicePath))
+	voice.Duration = duration
+	voice.ReplyToMessageID = replyTarget(message)
+	if _, err := sendMessage(bot, voice); err != nil {
+		log.Println("Error sending voice:", err)
+		sendText(bot, chatID, tr(lang, "voice.send_failed", err))
+	}
+}
+
+// downloadVoice writes the voice note and everything it is made from into
+// dir, the job's own directory, so removing that cleans up after it.
+func downloadVoice(url string, dir string, job *activeJob) (string, error) {
+	base := filepath.Join(dir, "voice")
+
+	cmd := ytDlpCommand(
+		"-x",
+		"--audio-format", "opus",
+		"--no-playlist",
+		"-o", base+".%(ext)s",
+		"--", url,
+	)
+
+	output, err := job.run(cmd)
+
+	log.Printf("yt-dlp output: %s", output)
+
+	if err != nil {
+		log.Println("Error executing yt-dlp:", err)
+		return "", err
+	}
+
+	// Telegram only renders Ogg/Opus as a voice note, so re-encode into an
+	// .ogg container at a voice-friendly bitrate.
+	opusPath := base + ".opus"
+	oggPath := base + ".ogg"
+
+	cmd = exec.Command("ffmpeg", "-i", opusPath, "-vn", "-c:a", "libopus", "-b:a", fmt.Sprintf("%dk", voiceBitrateKbps), "-f", "ogg", oggPath)
+	output, err = job.run(cmd)
+	if err != nil {
+		log.Printf("Error converting to ogg with ffmpeg: %s\n%s", err, string(output))
+		return "", err
+	}
+
+	return oggPath, nil
+}
+
+func validateVoiceFile(filePath string, job *activeJob) (int, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("could not check file size: %v", err)
+	}
+	if fileInfo.Size() > maxVoiceSize {
+		return 0, fmt.Errorf("voice file is too large")
+	}
+
+	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "a:0", "-show_entries", "stream=codec_name:format=format_name,duration", "-of", "default=noprint_wrappers=1", filePath)
+	output, err := job.run(cmd)
+	if errors.Is(err, errCancelled) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, fmt.Errorf("could not probe voice file: %v", err)
+	}
+
+	var codec, format string
+	var duration float64
+	for _, line := range strings.Split(string(output), "\n") {
+		key, value, found := strings.Cut(strings.TrimSpace(line), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "codec_name":
+			codec = value
+		case "format_name":
+			format = value
+		case "duration":
+			duration, _ = strconv.ParseFloat(value, 64)
+		}
+	}
+
+	if codec != "opus" || format != "ogg" {
+		return 0, fmt.Errorf("unexpected voice encoding %s/%s", format, codec)
+	}
+	if time.Duration(duration)*time.Second > maxVoiceDuration {
+		return 0, fmt.Errorf("voice message is longer than %d minutes", int(maxVoiceDuration.Minutes()))
+	}
+
+	return int(duration), nil
+}
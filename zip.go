@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// writeZip streams the files into an archive on disk and returns its size.
+func writeZip(zipPath string, files []string, names []string) (int64, error) {
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return 0, fmt.Errorf("could not create zip: %v", err)
+	}
+	defer out.Close()
+
+	archive := zip.NewWriter(out)
+	for i, path := range files {
+		// mp3 data doesn't compress, so store it as-is.
+		entry, err := archive.CreateHeader(&zip.FileHeader{Name: names[i], Method: zip.Store})
+		if err != nil {
+			return 0, fmt.Errorf("could not add %s to zip: %v", names[i], err)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return 0, fmt.Errorf("could not add %s to zip: %v", names[i], err)
+		}
+		_, err = io.Copy(entry, in)
+		in.Close()
+		if err != nil {
+			return 0, fmt.Errorf("could not add %s to zip: %v", names[i], err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return 0, fmt.Errorf("could not finish zip: %v", err)
+	}
+
+	fileInfo, err := out.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fileInfo.Size(), nil
+}
+
+// sendZip reports false when the archive didn't fit, in which case the
+// original files are left for the caller to send.
+func sendZip(bot *tgbotapi.BotAPI, chatID int64, files []string, names []string, meta trackMeta) (bool, error) {
+	var total int64
+	for _, path := range files {
+		fileInfo, err := os.Stat(path)
+		if err != nil {
+			return false, fmt.Errorf("could not check file size: %v", err)
+		}
+		total += fileInfo.Size()
+	}
+	if total > maxFileSize {
+		return false, nil
+	}
+
+	zipPath := filepath.Join(filepath.Dir(files[0]), sanitizeFilename(meta.Title)+".zip")
+	defer removeTempFile(zipPath)
+
+	size, err := writeZip(zipPath, files, names)
+	if err != nil {
+		return false, err
+	}
+	if size > maxFileSize {
+		return false, nil
+	}
+
+	title := meta.Title
+	if title == "" {
+		title = "audio"
+	}
+	stopAction := startChatAction(bot, chatID, tgbotapi.ChatUploadDocument)
+	defer stopAction()
+	_, err = sendWithRetry(bot, func() (tgbotapi.Chattable, func(), error) {
+		file, err := os.Open(zipPath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not open zip: %v", err)
+		}
+		doc := tgbotapi.NewDocument(chatID, tgbotapi.FileReader{Name: sanitizeFilename(title) + ".zip", Reader: file})
+		doc.Caption = truncateUTF16(meta.URL, maxCaptionLength)
+		doc.ReplyToMessageID = meta.ReplyTo
+		return doc, func() { file.Close() }, nil
+	})
+	if err != nil {
+		return false, fmt.Errorf("could not send zip: %v", err)
+	}
+
+	removeFiles(files)
+	return true, nil
+}
+
+func handleZipSetting(bot *tgbotapi.BotAPI, message *tgbotapi.Message, args string) {
+	var enabled bool
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "":
+		if prefs.get(message.Chat.ID).Zip {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "zip.current_on"))
+		} else {
+			sendText(bot, message.Chat.ID, tr(langOf(message), "zip.current_off"))
+		}
+		return
+	case "on":
+		enabled = true
+	case "off":
+		enabled = false
+	default:
+		sendText(bot, message.Chat.ID, tr(langOf(message), "zip.usage"))
+		return
+	}
+
+	err := prefs.update(message.Chat.ID, func(p *chatPrefs) {
+		p.Zip = enabled
+	})
+	if err != nil {
+		log.Println("Error saving prefs:", err)
+		sendText(bot, message.Chat.ID, tr(langOf(message), "prefs.save_failed"))
+		return
+	}
+
+	if enabled {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "zip.on"))
+	} else {
+		sendText(bot, message.Chat.ID, tr(langOf(message), "zip.off"))
+	}
+}